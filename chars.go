@@ -0,0 +1,20 @@
+package libucl
+
+func isKeyStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isKeyChar(c byte) bool {
+	return isKeyStart(c) || (c >= '0' && c <= '9') || c == '-'
+}
+
+// isAtomChar returns true if the byte can be part of a bare string.
+func isAtomChar(c byte) bool {
+	switch c {
+	case 0, ' ', '\t', '\r', '\n', '=', ':', ',', ';',
+		'{', '}', '[', ']', '"', '\'', '#':
+		return false
+	}
+
+	return true
+}
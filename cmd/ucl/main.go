@@ -0,0 +1,275 @@
+// Command ucl converts, queries and validates libucl configuration files
+// using the same parser as the libucl Go package.
+//
+// Usage:
+//
+//	ucl convert [-from format] [-to format] [file]
+//	ucl get [-to format] path [file]
+//	ucl validate -schema schema [file]
+//
+// If file is omitted or "-", the configuration is read from standard
+// input. The input formats are "ucl" (which includes JSON) and
+// "msgpack". The output formats are "ucl", "json", "json-compact",
+// "yaml" and "msgpack".
+//
+// The exit status is 0 on success, 1 if the configuration can't be
+// parsed, the path isn't found or validation fails, and 2 for usage
+// errors.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/mitchellh/go-libucl"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `Usage: ucl <command> [options] [args]
+
+Commands:
+    convert    Convert a configuration to another format
+    get        Print the value at a path in a configuration
+    validate   Validate a configuration against a JSON schema
+`
+
+// emitters are the output formats by name.
+var emitters = map[string]libucl.Emitter{
+	"ucl":          libucl.EmitConfig,
+	"json":         libucl.EmitJSON,
+	"json-compact": libucl.EmitJSONCompact,
+	"yaml":         libucl.EmitYAML,
+	"msgpack":      libucl.EmitMsgpack,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// cli holds the streams that a command reads from and writes to.
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "convert":
+		return c.convert(args[1:])
+	case "get":
+		return c.get(args[1:])
+	case "validate":
+		return c.validate(args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "ucl: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+func (c *cli) convert(args []string) int {
+	fs := c.flagSet("convert", "[-from format] [-to format] [file]")
+	from := fs.String("from", "ucl", "input format: ucl or msgpack")
+	to := fs.String("to", "json", "output format")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	emitter, ok := emitters[*to]
+	if !ok {
+		fmt.Fprintf(c.stderr, "ucl: unknown output format %q\n", *to)
+		return exitUsage
+	}
+
+	obj, code := c.parse(fs.Arg(0), *from)
+	if obj == nil {
+		return code
+	}
+	defer obj.Close()
+
+	return c.emit(obj, emitter)
+}
+
+func (c *cli) get(args []string) int {
+	fs := c.flagSet("get", "[-from format] [-to format] path [file]")
+	from := fs.String("from", "ucl", "input format: ucl or msgpack")
+	to := fs.String("to", "json", "output format")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	emitter, ok := emitters[*to]
+	if !ok {
+		fmt.Fprintf(c.stderr, "ucl: unknown output format %q\n", *to)
+		return exitUsage
+	}
+
+	obj, code := c.parse(fs.Arg(1), *from)
+	if obj == nil {
+		return code
+	}
+	defer obj.Close()
+
+	path := fs.Arg(0)
+	value := obj.LookupPath(path)
+	if value == nil {
+		fmt.Fprintf(c.stderr, "ucl: path not found: %s\n", path)
+		return exitError
+	}
+	defer value.Close()
+
+	return c.emit(value, emitter)
+}
+
+func (c *cli) validate(args []string) int {
+	fs := c.flagSet("validate", "-schema schema [file]")
+	from := fs.String("from", "ucl", "input format: ucl or msgpack")
+	schemaPath := fs.String("schema", "", "path to the JSON schema")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *schemaPath == "" || fs.NArg() > 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	schema, code := c.parse(*schemaPath, "ucl")
+	if schema == nil {
+		return code
+	}
+	defer schema.Close()
+
+	obj, code := c.parse(fs.Arg(0), *from)
+	if obj == nil {
+		return code
+	}
+	defer obj.Close()
+
+	if err := obj.Validate(schema); err != nil {
+		fmt.Fprintf(c.stderr, "ucl: %s: %s\n", displayName(fs.Arg(0)), err)
+		return exitError
+	}
+
+	return exitOK
+}
+
+func (c *cli) flagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: ucl %s %s\n\n", name, args)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parse parses the configuration at path, or standard input if path is
+// empty or "-". If parsing fails, the error is reported and the returned
+// object is nil.
+func (c *cli) parse(path, format string) (*libucl.Object, int) {
+	p := libucl.NewParser(0)
+	defer p.Close()
+
+	var err error
+	switch format {
+	case "ucl":
+		if path == "" || path == "-" {
+			var data []byte
+			data, err = ioutil.ReadAll(c.stdin)
+			if err == nil {
+				err = p.AddString(string(data))
+			}
+		} else {
+			err = p.AddFile(path)
+		}
+	case "msgpack":
+		var data []byte
+		if path == "" || path == "-" {
+			data, err = ioutil.ReadAll(c.stdin)
+		} else {
+			data, err = ioutil.ReadFile(path)
+		}
+		if err == nil {
+			err = p.AddMsgpack(data)
+		}
+	default:
+		fmt.Fprintf(c.stderr, "ucl: unknown input format %q\n", format)
+		return nil, exitUsage
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "ucl: %s: %s\n", displayName(path), err)
+		return nil, exitError
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		fmt.Fprintf(c.stderr, "ucl: %s: no configuration\n", displayName(path))
+		return nil, exitError
+	}
+
+	return obj, exitOK
+}
+
+func (c *cli) emit(obj *libucl.Object, emitter libucl.Emitter) int {
+	w := &lastByteWriter{w: c.stdout}
+	if err := obj.EmitTo(w, emitter); err != nil {
+		fmt.Fprintf(c.stderr, "ucl: %s\n", err)
+		return exitError
+	}
+
+	// Text formats don't always end with a newline.
+	if emitter != libucl.EmitMsgpack && w.last != '\n' {
+		fmt.Fprintln(c.stdout)
+	}
+
+	return exitOK
+}
+
+// lastByteWriter is an io.Writer that remembers the last byte written.
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (w *lastByteWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.last = p[n-1]
+	}
+
+	return n, err
+}
+
+func displayName(path string) string {
+	if path == "" || path == "-" {
+		return "<stdin>"
+	}
+
+	return path
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testRun(t *testing.T, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func testFile(t *testing.T, data string) string {
+	tf, err := ioutil.TempFile("", "ucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tf.Write([]byte(data))
+	tf.Close()
+
+	return tf.Name()
+}
+
+func TestRun_noArgs(t *testing.T) {
+	code, _, stderr := testRun(t, "")
+	if code != exitUsage {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(stderr, "Usage:") {
+		t.Fatalf("bad: %#v", stderr)
+	}
+}
+
+func TestRun_unknownCommand(t *testing.T) {
+	code, _, _ := testRun(t, "", "nope")
+	if code != exitUsage {
+		t.Fatalf("bad: %d", code)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	code, stdout, stderr := testRun(t, "foo = bar;", "convert", "-to", "json-compact")
+	if code != exitOK {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	expected := "{\"foo\":\"bar\"}\n"
+	if stdout != expected {
+		t.Fatalf("bad: %#v", stdout)
+	}
+}
+
+func TestConvert_file(t *testing.T) {
+	path := testFile(t, "foo = bar;")
+	defer os.Remove(path)
+
+	code, stdout, stderr := testRun(t, "", "convert", "-to", "ucl", path)
+	if code != exitOK {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	expected := "foo = \"bar\";\n"
+	if stdout != expected {
+		t.Fatalf("bad: %#v", stdout)
+	}
+}
+
+func TestConvert_msgpack(t *testing.T) {
+	code, packed, stderr := testRun(t, "foo = bar;", "convert", "-to", "msgpack")
+	if code != exitOK {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	code, stdout, stderr := testRun(t, packed,
+		"convert", "-from", "msgpack", "-to", "json-compact")
+	if code != exitOK {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	expected := "{\"foo\":\"bar\"}\n"
+	if stdout != expected {
+		t.Fatalf("bad: %#v", stdout)
+	}
+}
+
+func TestConvert_parseError(t *testing.T) {
+	code, _, stderr := testRun(t, "foo = {", "convert")
+	if code != exitError {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(stderr, "<stdin>") || !strings.Contains(stderr, "line") {
+		t.Fatalf("bad: %#v", stderr)
+	}
+}
+
+func TestConvert_badFormat(t *testing.T) {
+	code, _, _ := testRun(t, "foo = bar;", "convert", "-to", "xml")
+	if code != exitUsage {
+		t.Fatalf("bad: %d", code)
+	}
+}
+
+func TestGet(t *testing.T) {
+	code, stdout, stderr := testRun(t, "foo { bar = [1, 2]; }", "get", "foo.bar.1")
+	if code != exitOK {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	if stdout != "2\n" {
+		t.Fatalf("bad: %#v", stdout)
+	}
+}
+
+func TestGet_notFound(t *testing.T) {
+	code, _, stderr := testRun(t, "foo = bar;", "get", "baz")
+	if code != exitError {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(stderr, "baz") {
+		t.Fatalf("bad: %#v", stderr)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := testFile(t, `{
+		"type": "object",
+		"properties": { "port": { "type": "integer" } }
+	}`)
+	defer os.Remove(schema)
+
+	code, _, stderr := testRun(t, "port = 80;", "validate", "-schema", schema)
+	if code != exitOK {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	code, _, stderr = testRun(t, "port = eighty;", "validate", "-schema", schema)
+	if code != exitError {
+		t.Fatalf("bad: %d", code)
+	}
+	if stderr == "" {
+		t.Fatal("should have error output")
+	}
+}
+
+func TestValidate_noSchema(t *testing.T) {
+	code, _, _ := testRun(t, "port = 80;", "validate")
+	if code != exitUsage {
+		t.Fatalf("bad: %d", code)
+	}
+}
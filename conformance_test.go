@@ -0,0 +1,65 @@
+package libucl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The conformance tests are run by both the cgo and the pure Go backend,
+// so they check that the two parse configurations the same way. Each
+// .ucl file in testdata/conformance is compared, as JSON, with the .json
+// file of the same name.
+
+func TestConformance(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "conformance", "*.ucl"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no conformance tests")
+	}
+
+	for _, path := range paths {
+		p := NewParser(0)
+		p.RegisterVariable("NAME", "libucl")
+		if err := p.AddFile(path); err != nil {
+			p.Close()
+			t.Fatalf("%s: err: %s", path, err)
+		}
+
+		obj := p.Object()
+		actual, err := obj.Emit(EmitJSONCompact)
+		obj.Close()
+		p.Close()
+		if err != nil {
+			t.Fatalf("%s: err: %s", path, err)
+		}
+
+		expected, err := ioutil.ReadFile(strings.TrimSuffix(path, ".ucl") + ".json")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if !testJSONEqual(t, actual, string(expected)) {
+			t.Fatalf("%s: bad: %s\n\nexpected: %s", path, actual, expected)
+		}
+	}
+}
+
+// testJSONEqual returns true if a and b are the same JSON value, ignoring
+// formatting and the order of keys.
+func testJSONEqual(t testing.TB, a, b string) bool {
+	var av, bv interface{}
+	if err := json.Unmarshal([]byte(a), &av); err != nil {
+		t.Fatalf("err: %s\n\n%s", err, a)
+	}
+	if err := json.Unmarshal([]byte(b), &bv); err != nil {
+		t.Fatalf("err: %s\n\n%s", err, b)
+	}
+
+	return reflect.DeepEqual(av, bv)
+}
@@ -1,39 +1,338 @@
 package libucl
 
 import (
+	"encoding"
 	"fmt"
+	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const tagName = "libucl"
 
+// durationType is decoded from libucl times, which are in seconds.
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// UnusedKey is a key that wasn't decoded into any field. A field tagged
+// with "unusedKeys" can be a []UnusedKey instead of a []string to also
+// get where each key was set, if the object was parsed with
+// ParserSavePositions.
+type UnusedKey struct {
+	Key      string
+	Position Position
+}
+
+func (k UnusedKey) String() string {
+	if !k.Position.IsValid() {
+		return k.Key
+	}
+
+	return fmt.Sprintf("%s: %s", k.Position, k.Key)
+}
+
+// posError is a decoding error annotated with the position of the
+// object that caused it.
+type posError struct {
+	Pos Position
+	Err error
+}
+
+func (e *posError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Err)
+}
+
+// Metadata is information about what was decoded. Keys are dotted paths
+// named the same way as in decoding errors, such as "Server.Port", with
+// map keys in brackets such as "Tags[a]".
+type Metadata struct {
+	// Keys are the keys that were decoded into a struct field or map.
+	Keys []string
+
+	// Unused are the keys that weren't decoded into any struct field.
+	Unused []string
+
+	// Unset are the struct fields that no key was set for, and so kept
+	// the value they had before decoding.
+	Unset []string
+}
+
+// DuplicatePolicy is how a key that is set more than once is decoded
+// into a struct field that isn't a slice, array, map or struct. Slices
+// and arrays get every value, and maps and structs have the values
+// merged into them.
+type DuplicatePolicy int
+
+const (
+	// DuplicatePriority decodes the value with the highest priority. If
+	// more than one value has it, the last one wins.
+	DuplicatePriority DuplicatePolicy = iota
+
+	// DuplicateLast decodes the last value, regardless of priority.
+	DuplicateLast
+
+	// DuplicateFirst decodes the first value, regardless of priority.
+	DuplicateFirst
+
+	// DuplicateError returns an error with the positions of the first
+	// two values, if they are known.
+	DuplicateError
+)
+
+// DecodeHookFunc is a function that can replace how an object is decoded.
+// It is called with the object, its type and the type of the value it is
+// being decoded into.
+//
+// If it returns a nil value, decoding continues as normal. Otherwise the
+// returned value is used instead of the object, and must be assignable or
+// convertible to the target type.
+type DecodeHookFunc func(o *Object, from ObjectType, to reflect.Type) (interface{}, error)
+
+// NullPolicy is how a null value is decoded into a value that can't be
+// nil, such as a string, number or struct. Pointers, maps, slices and
+// interfaces are always set to nil by a null value.
+type NullPolicy int
+
+const (
+	// NullZero sets the value to its zero value.
+	NullZero NullPolicy = iota
+
+	// NullIgnore leaves the value as it is, such as a default that was
+	// set before decoding.
+	NullIgnore
+
+	// NullError returns an error.
+	NullError
+)
+
+// DecodeOptions are options for decoding with DecodeWithOptions.
+type DecodeOptions struct {
+	// Hooks are called in order before each value is decoded. The first
+	// hook to return a value wins.
+	Hooks []DecodeHookFunc
+
+	// AppendSlices appends decoded elements to slices that already have
+	// elements, rather than replacing them. Struct fields can override
+	// this with the "append" or "replace" tag options.
+	//
+	// Maps are always merged into: existing keys that aren't set are
+	// kept, and keys that are set are decoded into the existing value.
+	// Struct fields can start with an empty map instead with the
+	// "replace" tag option.
+	AppendSlices bool
+
+	// Null is how null values are decoded into values that can't be nil.
+	// To tell whether a struct field was set to null, tag a []string
+	// field with the "nullFields" option.
+	Null NullPolicy
+
+	// Duplicates is how keys that are set more than once are decoded into
+	// struct fields that can only hold one value.
+	Duplicates DuplicatePolicy
+
+	// SquashEmbedded squashes embedded structs and pointers to structs
+	// into the struct that embeds them, as encoding/json does, unless
+	// their tag gives them a key. By default, embedded structs are
+	// only squashed with the "squash" tag option, and are otherwise
+	// decoded from a key named after their type.
+	SquashEmbedded bool
+
+	// TagNames are the struct tags that keys and options are read from,
+	// in order. The first tag that is set on a field is used, so that
+	// types can be shared with other encodings, such as with
+	// []string{"libucl", "json"}. A tag of "-" skips the field, and
+	// options that only apply to encoding, such as "omitempty", are
+	// ignored. The default is just the "libucl" tag.
+	TagNames []string
+
+	// NameMapper maps the names of struct fields without a key in their
+	// tag to keys, such as SnakeCase. Keys then have to match exactly.
+	// By default, the field name is used and case is ignored.
+	NameMapper NameMapper
+
+	// Metadata, if not nil, is filled in with the keys that were and
+	// weren't decoded across the whole structure. It is reset at the
+	// start of each decode.
+	Metadata *Metadata
+
+	// ErrorUnused makes it an error for an object decoded into a struct
+	// to have keys that aren't decoded into any of its fields. Without
+	// a NameMapper, it is also an error for a field to match more than
+	// one key when ignoring case, rather than using the first one.
+	ErrorUnused bool
+
+	// ParserFlags are the flags of the parser used by
+	// Decoder.DecodeString and Decoder.DecodeFile.
+	ParserFlags ParserFlag
+}
+
+// Decoder decodes libucl objects into native Go structures with a set of
+// options. A Decoder can be used by multiple goroutines at once, unless
+// Metadata is set.
+type Decoder struct {
+	opts DecodeOptions
+}
+
+// NewDecoder returns a Decoder with the given options. If opts is nil,
+// the defaults are used.
+func NewDecoder(opts *DecodeOptions) *Decoder {
+	d := new(Decoder)
+	if opts != nil {
+		d.opts = *opts
+	}
+
+	return d
+}
+
+// Decode decodes o into v.
+func (d *Decoder) Decode(o *Object, v interface{}) error {
+	if md := d.opts.Metadata; md != nil {
+		*md = Metadata{}
+	}
+
+	dec := &decoder{opts: d.opts}
+	err := dec.decode("", o, reflect.ValueOf(v).Elem())
+	if md := d.opts.Metadata; md != nil {
+		sort.Strings(md.Keys)
+		sort.Strings(md.Unused)
+		sort.Strings(md.Unset)
+	}
+
+	return err
+}
+
+// DecodeString parses data and decodes it into v.
+func (d *Decoder) DecodeString(data string, v interface{}) error {
+	p := NewParser(d.opts.ParserFlags)
+	defer p.Close()
+	if err := p.AddString(data); err != nil {
+		return err
+	}
+
+	return d.decodeParser(p, v)
+}
+
+// DecodeFile parses the file at path and decodes it into v.
+func (d *Decoder) DecodeFile(path string, v interface{}) error {
+	p := NewParser(d.opts.ParserFlags)
+	defer p.Close()
+	if err := p.AddFile(path); err != nil {
+		return err
+	}
+
+	return d.decodeParser(p, v)
+}
+
+func (d *Decoder) decodeParser(p *Parser, v interface{}) error {
+	obj := p.Object()
+	defer obj.Close()
+
+	return d.Decode(obj, v)
+}
+
+// decoder holds the state for a single call to Decoder.Decode.
+type decoder struct {
+	opts DecodeOptions
+
+	// keys caches the keys of each struct type when there is a
+	// NameMapper.
+	keys map[reflect.Type][]string
+}
+
+// mappedKeys returns the keys of the fields of the struct type t, mapped
+// with the NameMapper.
+func (d *decoder) mappedKeys(t reflect.Type, info *structInfo) ([]string, error) {
+	if keys, ok := d.keys[t]; ok {
+		return keys, nil
+	}
+
+	keys, err := mappedKeys(info, d.opts.NameMapper)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.keys == nil {
+		d.keys = make(map[reflect.Type][]string)
+	}
+	d.keys[t] = keys
+
+	return keys, nil
+}
+
 // Decode decodes a libucl object into a native Go structure.
 func (o *Object) Decode(v interface{}) error {
-	return decode("", o, reflect.ValueOf(v).Elem())
+	return o.DecodeWithOptions(v, nil)
+}
+
+// DecodeWithOptions decodes a libucl object into a native Go structure
+// using the given options. If opts is nil, the defaults are used.
+func (o *Object) DecodeWithOptions(v interface{}, opts *DecodeOptions) error {
+	return NewDecoder(opts).Decode(o, v)
+}
+
+func (d *decoder) decode(name string, o *Object, result reflect.Value) error {
+	var err error
+	if ok, hookErr := d.decodeHooks(name, o, result); hookErr != nil {
+		err = hookErr
+	} else if !ok {
+		err = d.decodeValue(name, o, result)
+	}
+	if err == nil {
+		return nil
+	}
+
+	// Annotate the error with the position of the innermost object
+	// that has one.
+	if _, ok := err.(*posError); !ok {
+		if pos := o.Position(); pos.IsValid() {
+			err = &posError{Pos: pos, Err: err}
+		}
+	}
+
+	return err
 }
 
-func decode(name string, o *Object, result reflect.Value) error {
+func (d *decoder) decodeValue(name string, o *Object, result reflect.Value) error {
+	// A null is decoded the same way for every type, unless it is the
+	// start of an implicit array that is being decoded as a whole.
+	if o.Type() == ObjectTypeNull {
+		kind := result.Kind()
+		if !o.isImplicitArray() || (kind != reflect.Slice && kind != reflect.Array) {
+			return d.decodeNull(name, result)
+		}
+	}
+
+	if result.Type() == durationType {
+		return d.decodeIntoDuration(name, o, result)
+	}
+
 	switch result.Kind() {
+	case reflect.Array:
+		return d.decodeIntoArray(name, o, result)
 	case reflect.Bool:
-		return decodeIntoBool(name, o, result)
+		return d.decodeIntoBool(name, o, result)
 	case reflect.Interface:
 		// Interface is a bit weird. When we see an interface, we do
 		// our best effort to determine the type, and put it into that.
-		return decodeIntoInterface(name, o, result)
-	case reflect.Int:
-		return decodeIntoInt(name, o, result)
+		return d.decodeIntoInterface(name, o, result)
+	case reflect.Float32, reflect.Float64:
+		return d.decodeIntoFloat(name, o, result)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return d.decodeIntoInt(name, o, result)
 	case reflect.Map:
-		return decodeIntoMap(name, o, result)
+		return d.decodeIntoMap(name, o, result)
 	case reflect.Ptr:
-		return decodeIntoPtr(name, o, result)
+		return d.decodeIntoPtr(name, o, result)
 	case reflect.Slice:
-		return decodeIntoSlice(name, o, result)
+		return d.decodeIntoSlice(name, o, result)
 	case reflect.String:
-		return decodeIntoString(name, o, result)
+		return d.decodeIntoString(name, o, result)
 	case reflect.Struct:
-		return decodeIntoStruct(name, o, result)
+		return d.decodeIntoStruct(name, o, result)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return d.decodeIntoUint(name, o, result)
 	default:
 		return fmt.Errorf("%s: unsupported type: %s", name, result.Kind())
 	}
@@ -41,7 +340,100 @@ func decode(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoBool(name string, o *Object, result reflect.Value) error {
+// decodeHooks runs the hooks for the value. It returns true if a hook
+// set the value.
+func (d *decoder) decodeHooks(
+	name string, o *Object, result reflect.Value) (bool, error) {
+	for _, h := range d.opts.Hooks {
+		raw, err := h(o, o.Type(), result.Type())
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", name, err)
+		}
+		if raw == nil {
+			continue
+		}
+
+		v := reflect.ValueOf(raw)
+		switch {
+		case v.Type().AssignableTo(result.Type()):
+			result.Set(v)
+		case v.Type().ConvertibleTo(result.Type()):
+			result.Set(v.Convert(result.Type()))
+		default:
+			return false, fmt.Errorf(
+				"%s: decode hook returned %s, can't set %s",
+				name, v.Type(), result.Type())
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// StringToSliceHook returns a DecodeHookFunc that decodes strings into
+// slices of strings by splitting them on sep.
+func StringToSliceHook(sep string) DecodeHookFunc {
+	return func(o *Object, from ObjectType, to reflect.Type) (interface{}, error) {
+		if from != ObjectTypeString || to.Kind() != reflect.Slice ||
+			to.Elem().Kind() != reflect.String {
+			return nil, nil
+		}
+
+		s := o.ToString()
+		if s == "" {
+			return []string{}, nil
+		}
+
+		return strings.Split(s, sep), nil
+	}
+}
+
+func (d *decoder) decodeIntoArray(name string, o *Object, result reflect.Value) error {
+	resultType := result.Type()
+	resultElemType := resultType.Elem()
+
+	// Determine how we're doing this
+	expand := true
+	switch o.Type() {
+	case ObjectTypeObject:
+		expand = false
+	default:
+		// Array or anything else: we expand values and take it all
+	}
+
+	i := 0
+	iter := o.Iterate(expand)
+	defer iter.Close()
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		if i >= result.Len() {
+			elem.Close()
+			return fmt.Errorf(
+				"%s: expected %d elements, got more", name, result.Len())
+		}
+
+		val := reflect.Indirect(reflect.New(resultElemType))
+		fieldName := fmt.Sprintf("%s[%d]", name, i)
+		err := d.decode(fieldName, elem, val)
+		elem.Close()
+		if err != nil {
+			return err
+		}
+
+		result.Index(i).Set(val)
+
+		i++
+	}
+
+	if i != result.Len() {
+		return fmt.Errorf(
+			"%s: expected %d elements, got %d", name, result.Len(), i)
+	}
+
+	return nil
+}
+
+func (d *decoder) decodeIntoBool(name string, o *Object, result reflect.Value) error {
 	switch o.Type() {
 	case ObjectTypeString:
 		b, err := strconv.ParseBool(o.ToString())
@@ -57,23 +449,93 @@ func decodeIntoBool(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoInt(name string, o *Object, result reflect.Value) error {
+func (d *decoder) decodeIntoFloat(name string, o *Object, result reflect.Value) error {
+	switch o.Type() {
+	case ObjectTypeString:
+		f, err := parseFloat(o.ToString())
+		if err == nil {
+			result.SetFloat(f)
+		} else {
+			return fmt.Errorf("cannot parse '%s' as float: %s", name, err)
+		}
+	default:
+		result.SetFloat(o.ToFloat())
+	}
+
+	return nil
+}
+
+func (d *decoder) decodeIntoInt(name string, o *Object, result reflect.Value) error {
 	switch o.Type() {
 	case ObjectTypeString:
-		i, err := strconv.ParseInt(o.ToString(), 0, result.Type().Bits())
+		i, err := parseInt(o.ToString())
+		if err == nil && result.OverflowInt(i) {
+			err = fmt.Errorf("%d is out of range", i)
+		}
 		if err == nil {
 			result.SetInt(i)
 		} else {
 			return fmt.Errorf("cannot parse '%s' as int: %s", name, err)
 		}
 	default:
-		result.SetInt(o.ToInt())
+		if err := checkWholeTime(o); err != nil {
+			return fmt.Errorf("cannot parse '%s' as int: %s", name, err)
+		}
+
+		i := o.ToInt()
+		if result.OverflowInt(i) {
+			return fmt.Errorf("cannot parse '%s' as int: %d is out of range", name, i)
+		}
+
+		result.SetInt(i)
+	}
+
+	return nil
+}
+
+// decodeIntoDuration decodes a time such as 30s or "30s", which libucl
+// reads as a number of seconds, into a time.Duration. Other numbers are
+// decoded the same as into any other integer.
+func (d *decoder) decodeIntoDuration(name string, o *Object, result reflect.Value) error {
+	switch o.Type() {
+	case ObjectTypeTime:
+		v, err := secondsDuration(o.ToFloat())
+		if err != nil {
+			return fmt.Errorf("cannot parse '%s' as duration: %s", name, err)
+		}
+
+		result.SetInt(int64(v))
+		return nil
+	case ObjectTypeString:
+		v, ok, err := parseDuration(o.ToString())
+		if err != nil {
+			return fmt.Errorf("cannot parse '%s' as duration: %s", name, err)
+		}
+		if ok {
+			result.SetInt(int64(v))
+			return nil
+		}
+	}
+
+	return d.decodeIntoInt(name, o, result)
+}
+
+// checkWholeTime returns an error if o is a time that isn't a whole
+// number of seconds, such as 100ms, which would be truncated as an
+// integer.
+func checkWholeTime(o *Object) error {
+	if o.Type() != ObjectTypeTime {
+		return nil
+	}
+
+	if f := o.ToFloat(); f != math.Trunc(f) {
+		return fmt.Errorf("%s seconds is not a whole number", formatDouble(f))
 	}
 
 	return nil
 }
 
-func decodeIntoInterface(name string, o *Object, result reflect.Value) error {
+func (d *decoder) decodeIntoInterface(name string, o *Object, result reflect.Value) error {
 	var set reflect.Value
 	redecode := true
 
@@ -87,7 +549,7 @@ func decodeIntoInterface(name string, o *Object, result reflect.Value) error {
 		defer iter.Close()
 		for o := iter.Next(); o != nil; o = iter.Next() {
 			raw := new(interface{})
-			err := decode(name, o, reflect.Indirect(reflect.ValueOf(raw)))
+			err := d.decode(name, o, reflect.Indirect(reflect.ValueOf(raw)))
 			o.Close()
 
 			if err != nil {
@@ -100,6 +562,9 @@ func decodeIntoInterface(name string, o *Object, result reflect.Value) error {
 		set = reflect.ValueOf(result)
 	case ObjectTypeBoolean:
 		set = reflect.Indirect(reflect.New(reflect.TypeOf(o.ToBool())))
+	case ObjectTypeFloat, ObjectTypeTime:
+		var result float64
+		set = reflect.Indirect(reflect.New(reflect.TypeOf(result)))
 	case ObjectTypeInt:
 		var result int
 		set = reflect.Indirect(reflect.New(reflect.TypeOf(result)))
@@ -116,7 +581,7 @@ func decodeIntoInterface(name string, o *Object, result reflect.Value) error {
 			inner := o.Iterate(true)
 			for o2 := inner.Next(); o2 != nil; o2 = inner.Next() {
 				var raw interface{}
-				err = decode(name, o2, reflect.Indirect(reflect.ValueOf(&raw)))
+				err = d.decode(name, o2, reflect.Indirect(reflect.ValueOf(&raw)))
 				o2.Close()
 				if err != nil {
 					break
@@ -143,7 +608,7 @@ func decodeIntoInterface(name string, o *Object, result reflect.Value) error {
 	}
 
 	if redecode {
-		if err := decode(name, o, set); err != nil {
+		if err := d.decode(name, o, set); err != nil {
 			return err
 		}
 	}
@@ -152,7 +617,7 @@ func decodeIntoInterface(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoMap(name string, o *Object, result reflect.Value) error {
+func (d *decoder) decodeIntoMap(name string, o *Object, result reflect.Value) error {
 	if o.Type() != ObjectTypeObject {
 		return fmt.Errorf("%s: not an object type, can't decode to map", name)
 	}
@@ -160,9 +625,6 @@ func decodeIntoMap(name string, o *Object, result reflect.Value) error {
 	resultType := result.Type()
 	resultElemType := resultType.Elem()
 	resultKeyType := resultType.Key()
-	if resultKeyType.Kind() != reflect.String {
-		return fmt.Errorf("%s: map must have string keys", name)
-	}
 
 	// Make a map to store our result
 	resultMap := result
@@ -179,7 +641,11 @@ func decodeIntoMap(name string, o *Object, result reflect.Value) error {
 		for elem := iter.Next(); elem != nil; elem = iter.Next() {
 			fieldName := fmt.Sprintf("%s[%s]", name, elem.Key())
 
-			key := reflect.ValueOf(elem.Key())
+			key, err := decodeMapKey(fieldName, elem.Key(), resultKeyType)
+			if err != nil {
+				elem.Close()
+				return err
+			}
 
 			// The value we have to be decode
 			val := reflect.Indirect(reflect.New(resultElemType))
@@ -190,12 +656,16 @@ func decodeIntoMap(name string, o *Object, result reflect.Value) error {
 				val.Set(oldVal)
 			}
 
-			err := decode(fieldName, elem, val)
+			err = d.decode(fieldName, elem, val)
 			elem.Close()
 			if err != nil {
 				return err
 			}
 
+			if md := d.opts.Metadata; md != nil {
+				md.Keys = append(md.Keys, fieldName)
+			}
+
 			resultMap.SetMapIndex(key, val)
 		}
 	}
@@ -206,13 +676,87 @@ func decodeIntoMap(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoPtr(name string, o *Object, result reflect.Value) error {
+// decodeMapKey converts a key into a map key of the given type. Keys are
+// parsed the same way as string values decoded into the same type.
+func decodeMapKey(name, key string, keyType reflect.Type) (reflect.Value, error) {
+	result := reflect.New(keyType).Elem()
+
+	if u, ok := result.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(key)); err != nil {
+			return result, fmt.Errorf("cannot parse '%s' as key: %s", name, err)
+		}
+
+		return result, nil
+	}
+
+	var err error
+	switch keyType.Kind() {
+	case reflect.String:
+		result.SetString(key)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(key); err == nil {
+			result.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if i, err = parseInt(key); err == nil {
+			if result.OverflowInt(i) {
+				err = fmt.Errorf("%d is out of range", i)
+			} else {
+				result.SetInt(i)
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var i uint64
+		if i, err = parseUint(key); err == nil {
+			if result.OverflowUint(i) {
+				err = fmt.Errorf("%d is out of range", i)
+			} else {
+				result.SetUint(i)
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = parseFloat(key); err == nil {
+			result.SetFloat(f)
+		}
+	default:
+		return result, fmt.Errorf(
+			"%s: unsupported map key type: %s", name, keyType)
+	}
+	if err != nil {
+		return result, fmt.Errorf("cannot parse '%s' as key: %s", name, err)
+	}
+
+	return result, nil
+}
+
+func (d *decoder) decodeNull(name string, result reflect.Value) error {
+	switch result.Kind() {
+	case reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		result.Set(reflect.Zero(result.Type()))
+		return nil
+	}
+
+	switch d.opts.Null {
+	case NullIgnore:
+	case NullError:
+		return fmt.Errorf("%s: null is not allowed for %s", name, result.Type())
+	default:
+		result.Set(reflect.Zero(result.Type()))
+	}
+
+	return nil
+}
+
+func (d *decoder) decodeIntoPtr(name string, o *Object, result reflect.Value) error {
 	// Create an element of the concrete (non pointer) type and decode
 	// into that. Then set the value of the pointer to this type.
 	resultType := result.Type()
 	resultElemType := resultType.Elem()
 	val := reflect.New(resultElemType)
-	if err := decode(name, o, reflect.Indirect(val)); err != nil {
+	if err := d.decode(name, o, reflect.Indirect(val)); err != nil {
 		return err
 	}
 
@@ -220,13 +764,17 @@ func decodeIntoPtr(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoSlice(name string, o *Object, result reflect.Value) error {
-	// Create the slice
+func (d *decoder) decodeIntoSlice(name string, o *Object, result reflect.Value) error {
+	// Create the slice, or start with the existing one if we're
+	// appending to it.
 	resultType := result.Type()
 	resultElemType := resultType.Elem()
 	resultSliceType := reflect.SliceOf(resultElemType)
 	resultSlice := reflect.MakeSlice(
 		resultSliceType, 0, int(o.Len()))
+	if d.opts.AppendSlices && !result.IsNil() {
+		resultSlice = result
+	}
 
 	// Determine how we're doing this
 	expand := true
@@ -243,7 +791,7 @@ func decodeIntoSlice(name string, o *Object, result reflect.Value) error {
 	for elem := iter.Next(); elem != nil; elem = iter.Next() {
 		val := reflect.Indirect(reflect.New(resultElemType))
 		fieldName := fmt.Sprintf("%s[%d]", name, i)
-		err := decode(fieldName, elem, val)
+		err := d.decode(fieldName, elem, val)
 		elem.Close()
 		if err != nil {
 			return err
@@ -259,7 +807,38 @@ func decodeIntoSlice(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoString(name string, o *Object, result reflect.Value) error {
+func (d *decoder) decodeIntoUint(name string, o *Object, result reflect.Value) error {
+	switch o.Type() {
+	case ObjectTypeString:
+		i, err := parseUint(o.ToString())
+		if err == nil && result.OverflowUint(i) {
+			err = fmt.Errorf("%d is out of range", i)
+		}
+		if err == nil {
+			result.SetUint(i)
+		} else {
+			return fmt.Errorf("cannot parse '%s' as uint: %s", name, err)
+		}
+	default:
+		if err := checkWholeTime(o); err != nil {
+			return fmt.Errorf("cannot parse '%s' as uint: %s", name, err)
+		}
+
+		i := o.ToInt()
+		if i < 0 {
+			return fmt.Errorf("cannot parse '%s' as uint: %d is negative", name, i)
+		}
+		if result.OverflowUint(uint64(i)) {
+			return fmt.Errorf("cannot parse '%s' as uint: %d is out of range", name, i)
+		}
+
+		result.SetUint(uint64(i))
+	}
+
+	return nil
+}
+
+func (d *decoder) decodeIntoString(name string, o *Object, result reflect.Value) error {
 	objType := o.Type()
 	switch objType {
 	case ObjectTypeBoolean:
@@ -275,146 +854,137 @@ func decodeIntoString(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoStruct(name string, o *Object, result reflect.Value) error {
-	// This slice will keep track of all the structs we'll be decoding.
-	// There can be more than one struct if there are embedded structs
-	// that are squashed.
-	structs := make([]reflect.Value, 1, 5)
-	structs[0] = result
-
-	// Compile the list of all the fields that we're going to be decoding
-	// from all the structs.
-	fields := make(map[*reflect.StructField]reflect.Value)
-	for len(structs) > 0 {
-		structVal := structs[0]
-		structs = structs[1:]
-
-		structType := structVal.Type()
-		for i := 0; i < structType.NumField(); i++ {
-			fieldType := structType.Field(i)
-
-			if fieldType.Anonymous {
-				fieldKind := fieldType.Type.Kind()
-				if fieldKind != reflect.Struct {
-					return fmt.Errorf(
-						"%s: unsupported type to struct: %s",
-						fieldType.Name, fieldKind)
-				}
-
-				// We have an embedded field. We "squash" the fields down
-				// if specified in the tag.
-				squash := false
-				tagParts := strings.Split(fieldType.Tag.Get(tagName), ",")
-				for _, tag := range tagParts[1:] {
-					if tag == "squash" {
-						squash = true
-						break
-					}
-				}
-
-				if squash {
-					structs = append(structs, result.FieldByName(fieldType.Name))
-					continue
-				}
-			}
+func (d *decoder) decodeIntoStruct(name string, o *Object, result reflect.Value) error {
+	info, err := cachedStructInfo(result.Type(), &d.opts)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
 
-			// Normal struct field, store it away
-			fields[&fieldType] = structVal.Field(i)
+	// With a NameMapper, keys are mapped from the field names and must
+	// match exactly.
+	var mapped []string
+	if d.opts.NameMapper != nil {
+		mapped, err = d.mappedKeys(result.Type(), info)
+		if err != nil {
+			return prefixError(name, err)
 		}
 	}
 
+	keys := &keyIndex{o: o, strict: d.opts.ErrorUnused}
 	usedKeys := make(map[string]struct{})
-	decodedFields := make([]string, 0, len(fields))
+	decodedFields := make([]string, 0, len(info.fields))
 	decodedFieldsVal := make([]reflect.Value, 0)
+	nullFields := make([]string, 0)
+	nullFieldsVal := make([]reflect.Value, 0)
 	unusedKeysVal := make([]reflect.Value, 0)
-	for fieldType, field := range fields {
-		if !field.IsValid() {
-			// This should never happen
-			panic("field is not valid")
-		}
-
-		// If we can't set the field, then it is unexported or something,
-		// and we just continue onwards.
-		if !field.CanSet() {
-			continue
-		}
-
-		fieldName := fieldType.Name
-
-		tagValue := fieldType.Tag.Get(tagName)
-		tagParts := strings.SplitN(tagValue, ",", 2)
-		if len(tagParts) >= 2 {
-			switch tagParts[1] {
-			case "decodedFields":
-				decodedFieldsVal = append(decodedFieldsVal, field)
+	for i, f := range info.fields {
+		fieldName := f.key
+		var elem *Object
+		switch {
+		case f.special != "":
+		case mapped != nil:
+			fieldName = mapped[i]
+			if fieldName == "" {
+				// Hidden by another field with the same key.
 				continue
-			case "key":
-				field.SetString(o.Key())
-				continue
-			case "object":
-				// Increase the ref count
-				o.Ref()
+			}
+			elem = o.Get(fieldName)
+		default:
+			if elem, err = keys.get(fieldName); err != nil {
+				return prefixError(name, err)
+			}
+		}
 
-				// Sete the object
-				field.Set(reflect.ValueOf(o))
-				continue
-			case "unusedKeys":
-				unusedKeysVal = append(unusedKeysVal, field)
-				continue
+		// Fields of embedded pointers to structs are only allocated if
+		// there's something to decode into them.
+		field, err := fieldByIndex(result, f.index, f.special != "" || elem != nil)
+		if err != nil {
+			if elem != nil {
+				elem.Close()
 			}
+
+			return fmt.Errorf("%s: %s", fieldPath(name, fieldName), err)
 		}
 
-		if tagParts[0] != "" {
-			fieldName = tagParts[0]
+		switch f.special {
+		case "decodedFields":
+			decodedFieldsVal = append(decodedFieldsVal, field)
+			continue
+		case "key":
+			field.SetString(o.Key())
+			continue
+		case "nullFields":
+			nullFieldsVal = append(nullFieldsVal, field)
+			continue
+		case "object":
+			// Increase the ref count
+			o.Ref()
+
+			// Sete the object
+			field.Set(reflect.ValueOf(o))
+			continue
+		case "unusedKeys":
+			unusedKeysVal = append(unusedKeysVal, field)
+			continue
 		}
 
-		elem := o.Get(fieldName)
 		if elem == nil {
-			// Do a slower search by iterating over each key and
-			// doing case-insensitive search.
-			iter := o.Iterate(true)
-			for elem = iter.Next(); elem != nil; elem = iter.Next() {
-				if strings.EqualFold(elem.Key(), fieldName) {
-					break
-				}
-
-				elem.Close()
+			// No key matching this field.
+			if md := d.opts.Metadata; md != nil {
+				md.Unset = append(md.Unset, fieldPath(name, fieldName))
 			}
-			iter.Close()
 
-			if elem == nil {
-				// No key matching this field.
-				continue
-			}
+			continue
 		}
 
 		// Track the used key
 		usedKeys[elem.Key()] = struct{}{}
 
-		// If the name is empty string, then we're at the root, and we
-		// don't dot-join the fields.
-		if name != "" {
-			fieldName = fmt.Sprintf("%s.%s", name, fieldName)
-		}
+		fieldName = fieldPath(name, fieldName)
+
+		isNull := false
+		switch {
+		case field.Kind() == reflect.Slice && f.append:
+			// Decode into a new slice so we can append it to the
+			// existing one, regardless of the decoder default.
+			val := reflect.New(field.Type()).Elem()
+			err = d.decode(fieldName, elem, val)
+			if err == nil {
+				field.Set(reflect.AppendSlice(field, val))
+			}
+			isNull = elem.Type() == ObjectTypeNull
+		case field.Kind() == reflect.Slice || field.Kind() == reflect.Array:
+			if f.replace {
+				field.Set(reflect.Zero(field.Type()))
+			}
 
-		var err error
-		if field.Kind() == reflect.Slice {
-			err = decode(fieldName, elem, field)
-		} else {
+			err = d.decode(fieldName, elem, field)
+			isNull = elem.Type() == ObjectTypeNull
+		default:
+			if field.Kind() == reflect.Map && f.replace {
+				field.Set(reflect.Zero(field.Type()))
+			}
+
+			// If the key is set more than once, the duplicate policy
+			// decides which values are decoded.
+			var objs []*Object
 			iter := elem.Iterate(false)
-			for {
-				obj := iter.Next()
-				if obj == nil {
-					break
-				}
+			for obj := iter.Next(); obj != nil; obj = iter.Next() {
+				objs = append(objs, obj)
+			}
+			iter.Close()
 
-				err = decode(fieldName, obj, field)
-				obj.Close()
-				if err != nil {
+			var selected []*Object
+			selected, err = d.selectDuplicates(fieldName, field, objs)
+			for _, obj := range selected {
+				if err = d.decode(fieldName, obj, field); err != nil {
 					break
 				}
+				isNull = obj.Type() == ObjectTypeNull
+			}
+			for _, obj := range objs {
+				obj.Close()
 			}
-			iter.Close()
 		}
 		elem.Close()
 
@@ -422,16 +992,26 @@ func decodeIntoStruct(name string, o *Object, result reflect.Value) error {
 			return err
 		}
 
-		decodedFields = append(decodedFields, fieldType.Name)
+		decodedFields = append(decodedFields, f.name)
+		if md := d.opts.Metadata; md != nil {
+			md.Keys = append(md.Keys, fieldName)
+		}
+		if isNull {
+			nullFields = append(nullFields, f.name)
+		}
 	}
 
 	for _, v := range decodedFieldsVal {
 		v.Set(reflect.ValueOf(decodedFields))
 	}
+	for _, v := range nullFieldsVal {
+		v.Set(reflect.ValueOf(nullFields))
+	}
 
 	// If we want to know what keys are unused, compile thta
-	if len(unusedKeysVal) > 0 {
+	if len(unusedKeysVal) > 0 || d.opts.Metadata != nil || d.opts.ErrorUnused {
 		unusedKeys := make([]string, 0, int(o.Len())-len(usedKeys))
+		unusedKeyPositions := make([]UnusedKey, 0, cap(unusedKeys))
 
 		iter := o.Iterate(true)
 		defer iter.Close()
@@ -439,18 +1019,132 @@ func decodeIntoStruct(name string, o *Object, result reflect.Value) error {
 			k := elem.Key()
 			if _, ok := usedKeys[k]; !ok {
 				unusedKeys = append(unusedKeys, k)
+				unusedKeyPositions = append(unusedKeyPositions, UnusedKey{
+					Key:      k,
+					Position: elem.Position(),
+				})
 			}
 			elem.Close()
 		}
 
+		if md := d.opts.Metadata; md != nil {
+			for _, k := range unusedKeys {
+				md.Unused = append(md.Unused, fieldPath(name, k))
+			}
+		}
+
+		if d.opts.ErrorUnused && len(unusedKeys) > 0 {
+			paths := make([]string, len(unusedKeys))
+			for i, k := range unusedKeys {
+				paths[i] = fieldPath(name, k)
+			}
+
+			var err error = fmt.Errorf(
+				"unused keys: %s", strings.Join(paths, ", "))
+			if pos := unusedKeyPositions[0].Position; pos.IsValid() {
+				err = &posError{Pos: pos, Err: err}
+			}
+
+			return err
+		}
+
 		if len(unusedKeys) == 0 {
 			unusedKeys = nil
+			unusedKeyPositions = nil
 		}
 
 		for _, v := range unusedKeysVal {
-			v.Set(reflect.ValueOf(unusedKeys))
+			if v.Type() == reflect.TypeOf(unusedKeyPositions) {
+				v.Set(reflect.ValueOf(unusedKeyPositions))
+			} else {
+				v.Set(reflect.ValueOf(unusedKeys))
+			}
 		}
 	}
 
 	return nil
 }
+
+// prefixError returns err with the name of the value it is about, unless
+// it is the top value, which has no name.
+func prefixError(name string, err error) error {
+	if name == "" {
+		return err
+	}
+
+	return fmt.Errorf("%s: %s", name, err)
+}
+
+// fieldPath joins a key onto the path of its parent. At the root the
+// path is empty, and the key is used as is.
+func fieldPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+
+	return fmt.Sprintf("%s.%s", parent, key)
+}
+
+// hasTagOption returns true if the option is set in the parts of a tag.
+func hasTagOption(tagParts []string, option string) bool {
+	for _, part := range tagParts[1:] {
+		if part == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectDuplicates returns the values of a key that is set once for each
+// of objs that should be decoded into result, according to the
+// duplicate policy. Values for maps and structs are all merged together
+// unless they have a lower priority.
+func (d *decoder) selectDuplicates(
+	name string, result reflect.Value, objs []*Object) ([]*Object, error) {
+	if len(objs) <= 1 {
+		return objs, nil
+	}
+
+	kind := result.Kind()
+	if kind == reflect.Map || kind == reflect.Struct {
+		return highestPriority(objs), nil
+	}
+
+	switch d.opts.Duplicates {
+	case DuplicateFirst:
+		return objs[:1], nil
+	case DuplicateLast:
+		return objs[len(objs)-1:], nil
+	case DuplicateError:
+		first, second := objs[0].Position(), objs[1].Position()
+		if !first.IsValid() || !second.IsValid() {
+			return nil, fmt.Errorf("%s: set more than once", name)
+		}
+
+		return nil, fmt.Errorf(
+			"%s: set more than once, at %s and %s", name, first, second)
+	default:
+		return highestPriority(objs), nil
+	}
+}
+
+// highestPriority returns the objects that have the highest priority of
+// all of objs.
+func highestPriority(objs []*Object) []*Object {
+	var priority uint
+	for _, obj := range objs {
+		if p := obj.Priority(); p > priority {
+			priority = p
+		}
+	}
+
+	result := make([]*Object, 0, len(objs))
+	for _, obj := range objs {
+		if obj.Priority() == priority {
+			result = append(result, obj)
+		}
+	}
+
+	return result
+}
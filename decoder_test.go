@@ -1,10 +1,107 @@
 package libucl
 
 import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
+func TestDecoderDecodeString(t *testing.T) {
+	type Struct struct {
+		MaxConns int
+	}
+
+	d := NewDecoder(&DecodeOptions{NameMapper: SnakeCase})
+
+	var result Struct
+	if err := d.DecodeString("max_conns = 10;", &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.MaxConns != 10 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	if err := d.DecodeString("max_conns = ", &result); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestDecoderDecodeFile(t *testing.T) {
+	type Struct struct {
+		Foo string
+	}
+
+	tf, err := ioutil.TempFile("", "libucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Write([]byte("foo = bar;\nbaz = what;"))
+	tf.Close()
+
+	var result Struct
+	d := NewDecoder(nil)
+	if err := d.DecodeFile(tf.Name(), &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Foo != "bar" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	d = NewDecoder(&DecodeOptions{
+		ErrorUnused: true,
+		ParserFlags: ParserSavePositions,
+	})
+	err = d.DecodeFile(tf.Name(), &result)
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if !strings.HasPrefix(err.Error(), tf.Name()+":2:1:") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestObjectDecode_array(t *testing.T) {
+	var result struct {
+		Color [3]int
+	}
+
+	obj := testParseString(t, `color = [255, 128, 0];`)
+	defer obj.Close()
+
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Color != [3]int{255, 128, 0} {
+		t.Fatalf("bad: %#v", result.Color)
+	}
+}
+
+func TestObjectDecode_arrayLength(t *testing.T) {
+	for _, config := range []string{
+		`color = [255, 128];`,
+		`color = [255, 128, 0, 1];`,
+	} {
+		var result struct {
+			Color [3]int
+		}
+
+		obj := testParseString(t, config)
+		err := obj.Decode(&result)
+		obj.Close()
+		if err == nil {
+			t.Fatalf("should error: %s", config)
+		}
+	}
+}
+
 func TestObjectDecode_basic(t *testing.T) {
 	type Basic struct {
 		Bool    bool
@@ -38,6 +135,436 @@ func TestObjectDecode_basic(t *testing.T) {
 	}
 }
 
+func TestObjectDecode_byteSize(t *testing.T) {
+	var result struct {
+		Quoted   ByteSize
+		Unquoted ByteSize
+		Plain    ByteSize
+	}
+
+	obj := testParseString(t, `quoted = "512MB"; unquoted = 1kb; plain = 42;`)
+	defer obj.Close()
+
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Quoted != 512<<20 {
+		t.Fatalf("bad: %d", result.Quoted)
+	}
+	if result.Unquoted != 1024 {
+		t.Fatalf("bad: %d", result.Unquoted)
+	}
+	if result.Plain != 42 {
+		t.Fatalf("bad: %d", result.Plain)
+	}
+}
+
+func TestObjectDecode_duration(t *testing.T) {
+	var result struct {
+		Quoted   time.Duration
+		Unquoted time.Duration
+		Millis   time.Duration
+		Plain    time.Duration
+	}
+
+	obj := testParseString(t, `quoted = "30s"; unquoted = 2min; millis = 100ms; plain = 42;`)
+	defer obj.Close()
+
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Quoted != 30*time.Second {
+		t.Fatalf("bad: %s", result.Quoted)
+	}
+	if result.Unquoted != 2*time.Minute {
+		t.Fatalf("bad: %s", result.Unquoted)
+	}
+	if result.Millis != 100*time.Millisecond {
+		t.Fatalf("bad: %s", result.Millis)
+	}
+	if result.Plain != 42 {
+		t.Fatalf("bad: %s", result.Plain)
+	}
+}
+
+func TestObjectDecode_timeTruncated(t *testing.T) {
+	for _, config := range []string{
+		`value = 100ms;`,
+		`value = "100ms";`,
+	} {
+		var result struct {
+			Value int
+		}
+
+		obj := testParseString(t, config)
+		err := obj.Decode(&result)
+		obj.Close()
+		if err == nil {
+			t.Fatalf("should error: %s", config)
+		}
+	}
+
+	var result struct {
+		Value uint
+	}
+	obj := testParseString(t, `value = 2000ms;`)
+	defer obj.Close()
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Value != 2 {
+		t.Fatalf("bad: %d", result.Value)
+	}
+}
+
+func TestObjectDecode_metadata(t *testing.T) {
+	type Inner struct {
+		Name string
+		Port int
+	}
+
+	type Struct struct {
+		Server Inner
+		Tags   map[string]string
+		Debug  bool
+	}
+
+	obj := testParseString(t, `
+	server { name = "foo"; extra = 1; }
+	tags { a = "b"; }
+	other = true;
+	`)
+	defer obj.Close()
+
+	var md Metadata
+	var result Struct
+	opts := &DecodeOptions{Metadata: &md}
+	if err := obj.DecodeWithOptions(&result, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Metadata{
+		Keys:   []string{"Server", "Server.Name", "Tags", "Tags[a]"},
+		Unused: []string{"Server.extra", "other"},
+		Unset:  []string{"Debug", "Server.Port"},
+	}
+	if !reflect.DeepEqual(md, expected) {
+		t.Fatalf("bad: %#v", md)
+	}
+}
+
+func TestDecoder_metadataReset(t *testing.T) {
+	var result struct {
+		Name string
+		Port int
+	}
+
+	var md Metadata
+	d := NewDecoder(&DecodeOptions{Metadata: &md})
+	if err := d.DecodeString(`name = foo; other = 1;`, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := d.DecodeString(`port = 80;`, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Metadata{
+		Keys:  []string{"Port"},
+		Unset: []string{"Name"},
+	}
+	if !reflect.DeepEqual(md, expected) {
+		t.Fatalf("bad: %#v", md)
+	}
+}
+
+func TestObjectDecode_shadowedField(t *testing.T) {
+	type Base struct {
+		Name string
+	}
+
+	type Struct struct {
+		*Base
+		Name string
+	}
+
+	obj := testParseString(t, `name = svc;`)
+	defer obj.Close()
+
+	var md Metadata
+	var result Struct
+	opts := &DecodeOptions{Metadata: &md, SquashEmbedded: true}
+	if err := obj.DecodeWithOptions(&result, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Name != "svc" || result.Base != nil {
+		t.Fatalf("bad: %#v", result)
+	}
+	if !reflect.DeepEqual(md.Keys, []string{"Name"}) {
+		t.Fatalf("bad: %#v", md.Keys)
+	}
+}
+
+func TestObjectDecode_null(t *testing.T) {
+	type Struct struct {
+		Ptr       *string
+		Map       map[string]string
+		Slice     []string
+		Interface interface{}
+		Str       string
+		Num       int
+		Unset     string
+		Nulls     []string `libucl:",nullFields"`
+	}
+
+	obj := testParseString(t, `
+	ptr = null; map = null; slice = null; interface = null;
+	str = null; num = null;
+	`)
+	defer obj.Close()
+
+	str := "default"
+	result := Struct{
+		Ptr:       &str,
+		Map:       map[string]string{"a": "b"},
+		Slice:     []string{"a"},
+		Interface: 42,
+		Str:       "default",
+		Num:       42,
+		Unset:     "default",
+	}
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Struct{
+		Unset: "default",
+		Nulls: []string{"Ptr", "Map", "Slice", "Interface", "Str", "Num"},
+	}
+	sort.Strings(result.Nulls)
+	sort.Strings(expected.Nulls)
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_nullPolicy(t *testing.T) {
+	type Struct struct {
+		Str string
+		Ptr *string
+	}
+
+	obj := testParseString(t, `str = null; ptr = null;`)
+	defer obj.Close()
+
+	result := Struct{Str: "default"}
+	opts := &DecodeOptions{Null: NullIgnore}
+	if err := obj.DecodeWithOptions(&result, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Str != "default" || result.Ptr != nil {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	opts = &DecodeOptions{Null: NullError}
+	if err := obj.DecodeWithOptions(&result, opts); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestObjectDecode_nullInterface(t *testing.T) {
+	var result []interface{}
+
+	obj := testParseString(t, `foo = [1, null];`)
+	defer obj.Close()
+
+	obj = obj.Get("foo")
+	defer obj.Close()
+
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []interface{}{1, nil}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_numbers(t *testing.T) {
+	type Numbers struct {
+		Int8    int8
+		Uint    uint
+		UintStr uint32
+		Float   float64
+		Percent float64
+		Timeout float32
+	}
+
+	obj := testParseString(t, `
+	int8 = "100"; uint = 7; uintstr = "2k"; float = 1.5;
+	percent = "25%"; timeout = "10min";
+	`)
+	defer obj.Close()
+
+	var result Numbers
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Numbers{
+		Int8:    100,
+		Uint:    7,
+		UintStr: 2000,
+		Float:   1.5,
+		Percent: 0.25,
+		Timeout: 600,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_numbersOutOfRange(t *testing.T) {
+	var result struct {
+		Small  int8
+		USmall uint8
+	}
+
+	cases := []string{
+		`small = "1k";`,
+		`small = 300;`,
+		`small = -129;`,
+		`usmall = "1k";`,
+		`usmall = 70000;`,
+	}
+
+	for _, input := range cases {
+		obj := testParseString(t, input)
+		err := obj.Decode(&result)
+		obj.Close()
+		if err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Fatalf("input: %s\n\nbad: %v", input, err)
+		}
+	}
+}
+
+func TestObjectDecode_uintNegative(t *testing.T) {
+	var result struct {
+		Num uint
+	}
+
+	obj := testParseString(t, `num = -1;`)
+	defer obj.Close()
+
+	if err := obj.Decode(&result); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestObjectDecode_errorPosition(t *testing.T) {
+	var result struct {
+		Nested struct {
+			Num int
+		}
+	}
+
+	obj := testParsePositions(t, "nested {\n  num = nope;\n}")
+	defer obj.Close()
+
+	err := obj.Decode(&result)
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if !strings.HasPrefix(err.Error(), "2:3: ") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestObjectDecode_hooks(t *testing.T) {
+	type Hex []byte
+
+	var result struct {
+		Hosts []string
+		Key   Hex
+		Name  string
+	}
+
+	hexHook := func(o *Object, from ObjectType, to reflect.Type) (interface{}, error) {
+		if from != ObjectTypeString || to != reflect.TypeOf(Hex(nil)) {
+			return nil, nil
+		}
+
+		return hex.DecodeString(o.ToString())
+	}
+
+	obj := testParseString(t, `hosts = "a,b,c"; key = "cafe"; name = "foo";`)
+	defer obj.Close()
+
+	opts := &DecodeOptions{
+		Hooks: []DecodeHookFunc{StringToSliceHook(","), hexHook},
+	}
+	if err := obj.DecodeWithOptions(&result, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(result.Hosts, []string{"a", "b", "c"}) {
+		t.Fatalf("bad: %#v", result.Hosts)
+	}
+	if !reflect.DeepEqual(result.Key, Hex{0xca, 0xfe}) {
+		t.Fatalf("bad: %#v", result.Key)
+	}
+	if result.Name != "foo" {
+		t.Fatalf("bad: %#v", result.Name)
+	}
+}
+
+func TestObjectDecode_hooksError(t *testing.T) {
+	var result struct {
+		Name string
+	}
+
+	hook := func(o *Object, from ObjectType, to reflect.Type) (interface{}, error) {
+		if to.Kind() == reflect.String {
+			return nil, errors.New("nope")
+		}
+
+		return nil, nil
+	}
+
+	obj := testParseString(t, `name = "foo";`)
+	defer obj.Close()
+
+	opts := &DecodeOptions{Hooks: []DecodeHookFunc{hook}}
+	err := obj.DecodeWithOptions(&result, opts)
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if !strings.Contains(err.Error(), "nope") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestObjectDecode_hooksWrongType(t *testing.T) {
+	var result struct {
+		Num int
+	}
+
+	hook := func(o *Object, from ObjectType, to reflect.Type) (interface{}, error) {
+		return []string{"foo"}, nil
+	}
+
+	obj := testParseString(t, `num = 1;`)
+	defer obj.Close()
+
+	opts := &DecodeOptions{Hooks: []DecodeHookFunc{hook}}
+	if err := obj.DecodeWithOptions(&result, opts); err == nil {
+		t.Fatal("should error")
+	}
+}
+
 func TestObjectDecode_interface(t *testing.T) {
 	obj := testParseString(t, `
 	foo {
@@ -269,18 +796,117 @@ func TestObjectDecode_nestedStructRepeated(t *testing.T) {
 		Value Nested
 	}
 
-	obj := testParseString(t, `value { foo = "bar"; }; value { bar = "baz" };`)
+	obj := testParseString(t, `value { foo = "bar"; }; value { bar = "baz" };`)
+	defer obj.Close()
+
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Value.Foo != "bar" {
+		t.Fatalf("bad: %#v", result.Value.Foo)
+	}
+	if result.Value.Bar != "baz" {
+		t.Fatalf("bad: %#v", result.Value.Bar)
+	}
+}
+
+func TestObjectDecode_priority(t *testing.T) {
+	var result struct {
+		Foo string
+	}
+
+	obj := testParseString(t, `foo = bar; foo = baz;`)
+	defer obj.Close()
+
+	// Raise the priority of the first value so that it wins
+	foo := obj.Get("foo")
+	iter := foo.Iterate(false)
+	first := iter.Next()
+	first.SetPriority(5)
+	first.Close()
+	iter.Close()
+	foo.Close()
+
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Foo != "bar" {
+		t.Fatalf("bad: %#v", result.Foo)
+	}
+}
+
+func TestObjectDecode_duplicates(t *testing.T) {
+	type Struct struct {
+		Listen string
+		Hosts  []string
+	}
+
+	cases := []struct {
+		Policy   DuplicatePolicy
+		Expected string
+		Err      bool
+	}{
+		{DuplicatePriority, "b", false},
+		{DuplicateLast, "c", false},
+		{DuplicateFirst, "a", false},
+		{DuplicateError, "", true},
+	}
+
+	for _, tc := range cases {
+		obj := testParsePositions(t, `
+		listen = a;
+		listen = b;
+		listen = c;
+		hosts = x;
+		hosts = y;
+		`)
+
+		// Raise the priority of the second value
+		listen := obj.Get("listen")
+		iter := listen.Iterate(false)
+		iter.Next().Close()
+		second := iter.Next()
+		second.SetPriority(5)
+		second.Close()
+		iter.Close()
+		listen.Close()
+
+		var result Struct
+		err := obj.DecodeWithOptions(&result, &DecodeOptions{Duplicates: tc.Policy})
+		obj.Close()
+		if (err != nil) != tc.Err {
+			t.Fatalf("policy: %d\n\nerr: %s", tc.Policy, err)
+		}
+		if err != nil {
+			if !strings.Contains(err.Error(), "2:3 and 3:3") {
+				t.Fatalf("policy: %d\n\nbad: %s", tc.Policy, err)
+			}
+			continue
+		}
+
+		expected := Struct{Listen: tc.Expected, Hosts: []string{"x", "y"}}
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("policy: %d\n\nbad: %#v", tc.Policy, result)
+		}
+	}
+}
+
+func TestObjectDecode_duplicatesNoPositions(t *testing.T) {
+	var result struct {
+		Listen string
+	}
+
+	obj := testParseString(t, `listen = a; listen = b;`)
 	defer obj.Close()
 
-	if err := obj.Decode(&result); err != nil {
-		t.Fatalf("err: %s", err)
-	}
-
-	if result.Value.Foo != "bar" {
-		t.Fatalf("bad: %#v", result.Value.Foo)
+	err := obj.DecodeWithOptions(&result, &DecodeOptions{Duplicates: DuplicateError})
+	if err == nil {
+		t.Fatal("should error")
 	}
-	if result.Value.Bar != "baz" {
-		t.Fatalf("bad: %#v", result.Value.Bar)
+	if err.Error() != "Listen: set more than once" {
+		t.Fatalf("bad: %s", err)
 	}
 }
 
@@ -302,6 +928,55 @@ func TestObjectDecode_slice(t *testing.T) {
 	}
 }
 
+func TestObjectDecode_sliceAppend(t *testing.T) {
+	type Struct struct {
+		Default  []string
+		Appended []string `libucl:"appended,append"`
+		Replaced []string `libucl:",replace"`
+	}
+
+	obj := testParseString(t, `default = [b]; appended = [b]; replaced = [b];`)
+	defer obj.Close()
+
+	result := Struct{
+		Default:  []string{"a"},
+		Appended: []string{"a"},
+		Replaced: []string{"a"},
+	}
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Struct{
+		Default:  []string{"b"},
+		Appended: []string{"a", "b"},
+		Replaced: []string{"b"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// With the decoder defaulting to appending
+	result = Struct{
+		Default:  []string{"a"},
+		Appended: []string{"a"},
+		Replaced: []string{"a"},
+	}
+	opts := &DecodeOptions{AppendSlices: true}
+	if err := obj.DecodeWithOptions(&result, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = Struct{
+		Default:  []string{"a", "b"},
+		Appended: []string{"a", "b"},
+		Replaced: []string{"b"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
 func TestObjectDecode_sliceRepeatedKey(t *testing.T) {
 	obj := testParseString(t, "foo = foo; foo = bar;")
 	defer obj.Close()
@@ -387,6 +1062,91 @@ func TestObjectDecode_structKeys(t *testing.T) {
 	}
 }
 
+type testRegion string
+
+type testUpperKey string
+
+func (k *testUpperKey) UnmarshalText(text []byte) error {
+	*k = testUpperKey(strings.ToUpper(string(text)))
+	return nil
+}
+
+func TestObjectDecode_mapKeys(t *testing.T) {
+	var result struct {
+		Shards  map[int]string
+		Weights map[uint8]float64
+		Flags   map[bool]string
+		Regions map[testRegion]int
+		Upper   map[testUpperKey]int
+	}
+
+	obj := testParseString(t, `
+	shards { "1" = a; "2" = b; }
+	weights { "10" = 0.5; }
+	flags { true = enabled; }
+	regions { us-east = 1; }
+	upper { foo = 1; }
+	`)
+	defer obj.Close()
+
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(result.Shards, map[int]string{1: "a", 2: "b"}) {
+		t.Fatalf("bad: %#v", result.Shards)
+	}
+	if !reflect.DeepEqual(result.Weights, map[uint8]float64{10: 0.5}) {
+		t.Fatalf("bad: %#v", result.Weights)
+	}
+	if !reflect.DeepEqual(result.Flags, map[bool]string{true: "enabled"}) {
+		t.Fatalf("bad: %#v", result.Flags)
+	}
+	if !reflect.DeepEqual(result.Regions, map[testRegion]int{"us-east": 1}) {
+		t.Fatalf("bad: %#v", result.Regions)
+	}
+	if !reflect.DeepEqual(result.Upper, map[testUpperKey]int{"FOO": 1}) {
+		t.Fatalf("bad: %#v", result.Upper)
+	}
+}
+
+func TestObjectDecode_mapKeysInvalid(t *testing.T) {
+	var result map[int]string
+
+	obj := testParseString(t, `foo = bar;`)
+	defer obj.Close()
+
+	if err := obj.Decode(&result); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestObjectDecode_mapReplace(t *testing.T) {
+	var result struct {
+		Merged   map[string]string
+		Replaced map[string]string `libucl:",replace"`
+	}
+	result.Merged = map[string]string{"a": "default"}
+	result.Replaced = map[string]string{"a": "default"}
+
+	obj := testParseString(t, `merged { b = foo; } replaced { b = foo; }`)
+	defer obj.Close()
+
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{"a": "default", "b": "foo"}
+	if !reflect.DeepEqual(result.Merged, expected) {
+		t.Fatalf("bad: %#v", result.Merged)
+	}
+
+	expected = map[string]string{"b": "foo"}
+	if !reflect.DeepEqual(result.Replaced, expected) {
+		t.Fatalf("bad: %#v", result.Replaced)
+	}
+}
+
 func TestObjectDecode_mapStructNamed(t *testing.T) {
 	type Nested struct {
 		Name string `libucl:",key"`
@@ -504,6 +1264,235 @@ func TestObjectDecode_structSquash(t *testing.T) {
 	}
 }
 
+func TestObjectDecode_structSquashPtr(t *testing.T) {
+	type Base struct {
+		Name string
+	}
+
+	type Struct struct {
+		*Base `libucl:",squash"`
+		Port  int
+	}
+
+	obj := testParseString(t, "name = foo; port = 80;")
+	defer obj.Close()
+
+	var result Struct
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Base == nil || result.Name != "foo" || result.Port != 80 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// The embedded struct isn't allocated if none of its keys are set
+	obj2 := testParseString(t, "port = 80;")
+	defer obj2.Close()
+
+	result = Struct{}
+	if err := obj2.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Base != nil {
+		t.Fatalf("bad: %#v", result.Base)
+	}
+}
+
+func TestObjectDecode_structSquashEmbedded(t *testing.T) {
+	type Base struct {
+		Name string
+	}
+
+	type Other struct {
+		Value string
+	}
+
+	type Struct struct {
+		*Base
+		Other `libucl:"other"`
+		Port  int
+	}
+
+	obj := testParseString(t, `
+	name = foo;
+	port = 80;
+	other { value = bar; }
+	`)
+	defer obj.Close()
+
+	// By default, embedded structs are decoded from a key named after
+	// their type.
+	var result Struct
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Base != nil || result.Value != "bar" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	result = Struct{}
+	opts := &DecodeOptions{SquashEmbedded: true}
+	if err := obj.DecodeWithOptions(&result, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Base == nil || result.Name != "foo" {
+		t.Fatalf("bad: %#v", result.Base)
+	}
+	if result.Value != "bar" || result.Port != 80 {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_structTagNames(t *testing.T) {
+	type Struct struct {
+		Name   string `json:"name"`
+		Port   int    `libucl:"port" json:"listen_port"`
+		Secret string `json:"-"`
+	}
+
+	obj := testParseString(t, `
+	name = foo; port = 80; listen_port = 90; secret = bar;
+	`)
+	defer obj.Close()
+
+	var result Struct
+	opts := &DecodeOptions{TagNames: []string{"libucl", "json"}}
+	if err := obj.DecodeWithOptions(&result, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Struct{Name: "foo", Port: 80}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_structNameMapper(t *testing.T) {
+	type Struct struct {
+		MaxConns    int
+		HTTPAddress string
+		Name        string `libucl:"Name"`
+	}
+
+	obj := testParseString(t, `
+	max_conns = 10; http_address = "127.0.0.1"; Name = foo;
+	`)
+	defer obj.Close()
+
+	var result Struct
+	opts := &DecodeOptions{NameMapper: SnakeCase}
+	if err := obj.DecodeWithOptions(&result, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Struct{MaxConns: 10, HTTPAddress: "127.0.0.1", Name: "foo"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Keys must match exactly
+	result = Struct{}
+	opts = &DecodeOptions{NameMapper: ExactNames}
+	if err := obj.DecodeWithOptions(&result, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = Struct{Name: "foo"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_structNameMapperAmbiguous(t *testing.T) {
+	type Struct struct {
+		UserID string
+		UserId string
+	}
+
+	obj := testParseString(t, `user_id = foo;`)
+	defer obj.Close()
+
+	var result Struct
+	opts := &DecodeOptions{NameMapper: SnakeCase}
+	err := obj.DecodeWithOptions(&result, opts)
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if err.Error() != "fields UserID and UserId are both decoded from key 'user_id'" {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestObjectDecode_structNameMapperShadowed(t *testing.T) {
+	type Base struct {
+		Name   string
+		UserId string
+	}
+
+	type Struct struct {
+		*Base
+		Name   string
+		UserID string
+	}
+
+	obj := testParseString(t, `name = svc; user_id = foo;`)
+	defer obj.Close()
+
+	var result Struct
+	opts := &DecodeOptions{NameMapper: SnakeCase, SquashEmbedded: true}
+	if err := obj.DecodeWithOptions(&result, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Struct{Name: "svc", UserID: "foo"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_structAmbiguousKeys(t *testing.T) {
+	type Struct struct {
+		Name string
+	}
+
+	obj := testParseString(t, `NAME = foo; nAmE = bar;`)
+	defer obj.Close()
+
+	var result Struct
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Name != "foo" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	err := obj.DecodeWithOptions(&result, &DecodeOptions{ErrorUnused: true})
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if !strings.Contains(err.Error(), "ambiguous keys for Name: NAME, nAmE") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestObjectDecode_errorUnused(t *testing.T) {
+	type Struct struct {
+		Bar string
+	}
+
+	obj := testParsePositions(t, "bar = baz;\nfoo = what;")
+	defer obj.Close()
+
+	var result Struct
+	err := obj.DecodeWithOptions(&result, &DecodeOptions{ErrorUnused: true})
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if !strings.Contains(err.Error(), "2:1") || !strings.Contains(err.Error(), "foo") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
 func TestObjectDecode_structUnusedKeys(t *testing.T) {
 	type Struct struct {
 		Bar  string
@@ -527,3 +1516,113 @@ func TestObjectDecode_structUnusedKeys(t *testing.T) {
 		t.Fatalf("bad: %#v", result)
 	}
 }
+
+func TestObjectDecode_structUnusedKeyPositions(t *testing.T) {
+	type Struct struct {
+		Bar  string
+		Keys []UnusedKey `libucl:",unusedKeys"`
+	}
+
+	var result Struct
+
+	obj := testParsePositions(t, "bar = baz;\n  tiemout = 5;")
+	defer obj.Close()
+
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []UnusedKey{
+		{Key: "tiemout", Position: Position{Line: 2, Column: 3}},
+	}
+	if !reflect.DeepEqual(expected, result.Keys) {
+		t.Fatalf("bad: %#v", result.Keys)
+	}
+	if result.Keys[0].String() != "2:3: tiemout" {
+		t.Fatalf("bad: %s", result.Keys[0])
+	}
+}
+
+type benchConfig struct {
+	Name    string
+	Port    int
+	Debug   bool
+	Timeout float64
+	Hosts   []string
+	Labels  map[string]string
+	Server  struct {
+		Address string
+		TLS     bool `libucl:"tls"`
+	}
+}
+
+const benchConfigString = `
+name = "tenant";
+port = 8080;
+debug = true;
+timeout = 2.5s;
+hosts = ["a", "b", "c"];
+labels { env = "prod"; team = "core"; }
+server { address = "127.0.0.1"; tls = true; }
+`
+
+func BenchmarkObjectDecode_struct(b *testing.B) {
+	obj := testParseString(b, benchConfigString)
+	defer obj.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var result benchConfig
+		if err := obj.Decode(&result); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+	}
+}
+
+func BenchmarkObjectDecode_structExactKeys(b *testing.B) {
+	type Struct struct {
+		Name  string `libucl:"name"`
+		Port  int    `libucl:"port"`
+		Debug bool   `libucl:"debug"`
+	}
+
+	obj := testParseString(b, `name = "tenant"; port = 8080; debug = true;`)
+	defer obj.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var result Struct
+		if err := obj.Decode(&result); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+	}
+}
+
+func BenchmarkObjectDecode_interface(b *testing.B) {
+	obj := testParseString(b, benchConfigString)
+	defer obj.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var result map[string]interface{}
+		if err := obj.Decode(&result); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+	}
+}
+
+func BenchmarkParseAndDecode(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		obj, err := ParseString(benchConfigString)
+		if err != nil {
+			b.Fatalf("err: %s", err)
+		}
+
+		var result benchConfig
+		err = obj.Decode(&result)
+		obj.Close()
+		if err != nil {
+			b.Fatalf("err: %s", err)
+		}
+	}
+}
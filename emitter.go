@@ -0,0 +1,115 @@
+//go:build !purego
+// +build !purego
+
+package libucl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime/cgo"
+	"strconv"
+	"unsafe"
+)
+
+// #include "go-libucl.h"
+import "C"
+
+// emitWriter is the state of a single streaming emit. The emitter
+// callbacks can't return an error to libucl, so the first error is
+// recorded and all further output is dropped.
+type emitWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *emitWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+
+	_, w.err = w.w.Write(p)
+}
+
+func (w *emitWriter) writeString(s string) {
+	if w.err != nil {
+		return
+	}
+
+	_, w.err = w.w.WriteString(s)
+}
+
+// EmitTo converts this object to another format and writes it to w.
+// Unlike Emit, the output is streamed to w as it is generated rather
+// than built up in memory first.
+//
+// If the object has comments (see Comments), they are written back
+// when emitting with EmitConfig.
+func (o *Object) EmitTo(w io.Writer, t Emitter) error {
+	ew := &emitWriter{w: bufio.NewWriter(w)}
+
+	// The handle is passed to libucl as the user data of the emitter
+	// functions, so the callbacks can find the writer.
+	h := cgo.NewHandle(ew)
+	defer h.Delete()
+
+	funcs := C._go_emitter_functions(C.uintptr_t(h))
+	if !C.ucl_object_emit_full(o.object, uint32(t), &funcs, o.comments) {
+		return fmt.Errorf("failed to emit object")
+	}
+	if ew.err != nil {
+		return ew.err
+	}
+
+	return ew.w.Flush()
+}
+
+func lookupEmitter(h C.uintptr_t) *emitWriter {
+	w, _ := cgo.Handle(h).Value().(*emitWriter)
+	return w
+}
+
+//export go_emit_character
+func go_emit_character(h C.uintptr_t, c C.uchar, n C.size_t) C.int {
+	w := lookupEmitter(h)
+	if w == nil {
+		return -1
+	}
+
+	w.write(bytes.Repeat([]byte{byte(c)}, int(n)))
+	return 0
+}
+
+//export go_emit_len
+func go_emit_len(h C.uintptr_t, str *C.uchar, n C.size_t) C.int {
+	w := lookupEmitter(h)
+	if w == nil {
+		return -1
+	}
+
+	w.write(C.GoBytes(unsafe.Pointer(str), C.int(n)))
+	return 0
+}
+
+//export go_emit_int
+func go_emit_int(h C.uintptr_t, v C.int64_t) C.int {
+	w := lookupEmitter(h)
+	if w == nil {
+		return -1
+	}
+
+	w.writeString(strconv.FormatInt(int64(v), 10))
+	return 0
+}
+
+//export go_emit_double
+func go_emit_double(h C.uintptr_t, v C.double) C.int {
+	w := lookupEmitter(h)
+	if w == nil {
+		return -1
+	}
+
+	w.writeString(formatDouble(float64(v)))
+	return 0
+}
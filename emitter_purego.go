@@ -0,0 +1,453 @@
+//go:build purego
+// +build purego
+
+package libucl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// EmitTo converts this object to another format and writes it to w.
+// Unlike Emit, the output is streamed to w as it is generated rather
+// than built up in memory first.
+//
+// If the object has comments (see Comments), they are written back
+// when emitting with EmitConfig.
+func (o *Object) EmitTo(w io.Writer, t Emitter) error {
+	e := &emitter{w: bufio.NewWriter(w)}
+
+	switch t {
+	case EmitJSON:
+		e.flowValue(o.object, 0, false, false)
+	case EmitJSONCompact:
+		e.flowValue(o.object, 0, true, false)
+	case EmitConfig:
+		e.configTop(o.object)
+	case EmitYAML:
+		e.yamlTop(o.object)
+	case EmitMsgpack:
+		e.msgpack(o.object)
+	default:
+		return fmt.Errorf("failed to emit object")
+	}
+
+	return e.w.Flush()
+}
+
+// emitter writes objects in the same formats as the libucl emitters.
+// Write errors are kept by the bufio.Writer and returned by Flush.
+type emitter struct {
+	w *bufio.Writer
+}
+
+func (e *emitter) newline(indent int, compact bool) {
+	if compact {
+		return
+	}
+
+	e.w.WriteByte('\n')
+	e.indent(indent)
+}
+
+func (e *emitter) indent(indent int) {
+	for i := 0; i < indent; i++ {
+		e.w.WriteString("    ")
+	}
+}
+
+// flowValue writes v as JSON. With yaml set, keys are written as YAML
+// keys instead, which is how libucl writes nested YAML values.
+func (e *emitter) flowValue(v *object, indent int, compact, yaml bool) {
+	switch v.typ {
+	case ObjectTypeObject:
+		if len(v.keys) == 0 {
+			e.w.WriteString("{}")
+			return
+		}
+
+		e.w.WriteByte('{')
+		for i, k := range v.keys {
+			if i > 0 {
+				e.w.WriteByte(',')
+			}
+			e.newline(indent+1, compact)
+			e.flowKey(k.key, compact, yaml)
+			e.flowMember(k, indent+1, compact, yaml)
+		}
+		e.newline(indent, compact)
+		e.w.WriteByte('}')
+	case ObjectTypeArray:
+		e.flowArray(v.elems, indent, compact, yaml)
+	default:
+		e.scalar(v)
+	}
+}
+
+func (e *emitter) flowKey(key string, compact, yaml bool) {
+	switch {
+	case yaml:
+		e.key(key)
+		e.w.WriteString(": ")
+	case compact:
+		e.jsonString(key)
+		e.w.WriteByte(':')
+	default:
+		e.jsonString(key)
+		e.w.WriteString(": ")
+	}
+}
+
+// flowMember writes the value of a key, which is an array if the key was
+// set more than once.
+func (e *emitter) flowMember(v *object, indent int, compact, yaml bool) {
+	if v.next == nil {
+		e.flowValue(v, indent, compact, yaml)
+		return
+	}
+
+	e.flowArray(implicitArray(v), indent, compact, yaml)
+}
+
+func (e *emitter) flowArray(elems []*object, indent int, compact, yaml bool) {
+	if len(elems) == 0 {
+		e.w.WriteString("[]")
+		return
+	}
+
+	e.w.WriteByte('[')
+	for i, elem := range elems {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		e.newline(indent+1, compact)
+		e.flowValue(elem, indent+1, compact, yaml)
+	}
+	e.newline(indent, compact)
+	e.w.WriteByte(']')
+}
+
+// implicitArray returns the values of an implicit array.
+func implicitArray(v *object) []*object {
+	var result []*object
+	for ; v != nil; v = v.next {
+		result = append(result, v)
+	}
+
+	return result
+}
+
+func (e *emitter) scalar(v *object) {
+	switch v.typ {
+	case ObjectTypeInt:
+		e.w.WriteString(strconv.FormatInt(v.integer, 10))
+	case ObjectTypeFloat, ObjectTypeTime:
+		e.w.WriteString(formatDouble(v.float))
+	case ObjectTypeString:
+		e.jsonString(v.str)
+	case ObjectTypeBoolean:
+		e.w.WriteString(strconv.FormatBool(v.boolean))
+	default:
+		e.w.WriteString("null")
+	}
+}
+
+// jsonString writes s as a JSON string.
+func (e *emitter) jsonString(s string) {
+	e.w.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			e.w.WriteString(`\"`)
+		case '\\':
+			e.w.WriteString(`\\`)
+		case '\n':
+			e.w.WriteString(`\n`)
+		case '\r':
+			e.w.WriteString(`\r`)
+		case '\t':
+			e.w.WriteString(`\t`)
+		case '\b':
+			e.w.WriteString(`\b`)
+		case '\f':
+			e.w.WriteString(`\f`)
+		default:
+			if c < ' ' {
+				fmt.Fprintf(e.w, `\u%04x`, c)
+			} else {
+				e.w.WriteByte(c)
+			}
+		}
+	}
+	e.w.WriteByte('"')
+}
+
+// key writes a key of config or YAML output. It is quoted unless it can
+// be parsed back as a bare key.
+func (e *emitter) key(key string) {
+	if bareKey(key) {
+		e.w.WriteString(key)
+	} else {
+		e.jsonString(key)
+	}
+}
+
+func bareKey(key string) bool {
+	if key == "" || !isKeyStart(key[0]) {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; !isKeyChar(c) && c != '.' && c != '/' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e *emitter) configTop(v *object) {
+	if v.typ != ObjectTypeObject {
+		e.configValue(v, 0)
+		e.w.WriteByte('\n')
+		return
+	}
+
+	for _, k := range v.keys {
+		e.configMember(k, 0)
+	}
+}
+
+// configMember writes the value of a key. If the key was set more than
+// once, it is written once for each value.
+func (e *emitter) configMember(v *object, indent int) {
+	for ; v != nil; v = v.next {
+		e.comments(v, indent)
+		e.indent(indent)
+		e.key(v.key)
+
+		switch v.typ {
+		case ObjectTypeObject:
+			e.w.WriteString(" {\n")
+			for _, k := range v.keys {
+				e.configMember(k, indent+1)
+			}
+			e.indent(indent)
+			e.w.WriteString("}")
+		case ObjectTypeArray:
+			e.w.WriteString(" [\n")
+			e.configElems(v, indent+1)
+			e.indent(indent)
+			e.w.WriteString("]")
+		default:
+			e.w.WriteString(" = ")
+			e.configScalar(v)
+			e.w.WriteString(";")
+		}
+		e.endLine(v, indent)
+	}
+}
+
+// configValue writes an array element, or a top-level value that isn't
+// an object.
+func (e *emitter) configValue(v *object, indent int) {
+	switch v.typ {
+	case ObjectTypeObject:
+		e.w.WriteString("{\n")
+		for _, k := range v.keys {
+			e.configMember(k, indent+1)
+		}
+		e.indent(indent)
+		e.w.WriteByte('}')
+	case ObjectTypeArray:
+		e.w.WriteString("[\n")
+		e.configElems(v, indent+1)
+		e.indent(indent)
+		e.w.WriteByte(']')
+	default:
+		e.configScalar(v)
+	}
+}
+
+func (e *emitter) configElems(v *object, indent int) {
+	for _, elem := range v.elems {
+		e.comments(elem, indent)
+		e.indent(indent)
+		e.configValue(elem, indent)
+		e.w.WriteString(",")
+		e.endLine(elem, indent)
+	}
+}
+
+func (e *emitter) configScalar(v *object) {
+	// Long multiline strings are written as heredocs, the same as libucl.
+	if v.typ == ObjectTypeString && v.multiline && len(v.str) > 1024 &&
+		strings.Contains(v.str, "\n") {
+		e.w.WriteString("<<EOD\n")
+		e.w.WriteString(v.str)
+		e.w.WriteString("\nEOD")
+		return
+	}
+
+	e.scalar(v)
+}
+
+func (e *emitter) comments(v *object, indent int) {
+	for _, c := range v.comments {
+		e.indent(indent)
+		e.w.WriteString(c)
+		e.w.WriteByte('\n')
+	}
+}
+
+// endLine ends the line that v was written on, with its line comment,
+// and writes its trailing comments.
+func (e *emitter) endLine(v *object, indent int) {
+	if v.lineComment != "" {
+		e.w.WriteByte(' ')
+		e.w.WriteString(v.lineComment)
+	}
+	e.w.WriteByte('\n')
+
+	for _, c := range v.trailing {
+		e.indent(indent)
+		e.w.WriteString(c)
+		e.w.WriteByte('\n')
+	}
+}
+
+func (e *emitter) yamlTop(v *object) {
+	if v.typ != ObjectTypeObject {
+		e.flowValue(v, 0, false, true)
+		return
+	}
+
+	for i, k := range v.keys {
+		if i > 0 {
+			e.w.WriteByte('\n')
+		}
+		e.flowKey(k.key, false, true)
+		e.flowMember(k, 0, false, true)
+	}
+}
+
+// msgpack writes v as msgpack. Like libucl, only the first value of an
+// implicit array is written.
+func (e *emitter) msgpack(v *object) {
+	switch v.typ {
+	case ObjectTypeObject:
+		e.msgpackHeader(len(v.keys), 0x80, 0xde, 0xdf)
+		for _, k := range v.keys {
+			e.msgpackString(k.key)
+			e.msgpack(k)
+		}
+	case ObjectTypeArray:
+		e.msgpackHeader(len(v.elems), 0x90, 0xdc, 0xdd)
+		for _, elem := range v.elems {
+			e.msgpack(elem)
+		}
+	case ObjectTypeInt:
+		e.msgpackInt(v.integer)
+	case ObjectTypeFloat, ObjectTypeTime:
+		var buf [9]byte
+		buf[0] = 0xcb
+		binary.BigEndian.PutUint64(buf[1:], math.Float64bits(v.float))
+		e.w.Write(buf[:])
+	case ObjectTypeString:
+		e.msgpackString(v.str)
+	case ObjectTypeBoolean:
+		if v.boolean {
+			e.w.WriteByte(0xc3)
+		} else {
+			e.w.WriteByte(0xc2)
+		}
+	default:
+		e.w.WriteByte(0xc0)
+	}
+}
+
+// msgpackHeader writes the header of a map or array with n entries,
+// using the fix, 16-bit or 32-bit form.
+func (e *emitter) msgpackHeader(n int, fix, b16, b32 byte) {
+	var buf [5]byte
+	switch {
+	case n < 16:
+		e.w.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf[0] = b16
+		binary.BigEndian.PutUint16(buf[1:], uint16(n))
+		e.w.Write(buf[:3])
+	default:
+		buf[0] = b32
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		e.w.Write(buf[:5])
+	}
+}
+
+func (e *emitter) msgpackString(s string) {
+	var buf [5]byte
+	switch n := len(s); {
+	case n < 32:
+		e.w.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf[0] = 0xd9
+		buf[1] = byte(n)
+		e.w.Write(buf[:2])
+	case n <= math.MaxUint16:
+		buf[0] = 0xda
+		binary.BigEndian.PutUint16(buf[1:], uint16(n))
+		e.w.Write(buf[:3])
+	default:
+		buf[0] = 0xdb
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		e.w.Write(buf[:5])
+	}
+	e.w.WriteString(s)
+}
+
+// msgpackInt writes i in the smallest form that holds it.
+func (e *emitter) msgpackInt(i int64) {
+	var buf [9]byte
+	switch {
+	case i >= 0 && i < 128:
+		e.w.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		buf[0] = 0xcc
+		buf[1] = byte(i)
+		e.w.Write(buf[:2])
+	case i >= 0 && i <= math.MaxUint16:
+		buf[0] = 0xcd
+		binary.BigEndian.PutUint16(buf[1:], uint16(i))
+		e.w.Write(buf[:3])
+	case i >= 0 && i <= math.MaxUint32:
+		buf[0] = 0xce
+		binary.BigEndian.PutUint32(buf[1:], uint32(i))
+		e.w.Write(buf[:5])
+	case i >= 0:
+		buf[0] = 0xcf
+		binary.BigEndian.PutUint64(buf[1:], uint64(i))
+		e.w.Write(buf[:9])
+	case i >= -32:
+		e.w.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		buf[0] = 0xd0
+		buf[1] = byte(i)
+		e.w.Write(buf[:2])
+	case i >= math.MinInt16:
+		buf[0] = 0xd1
+		binary.BigEndian.PutUint16(buf[1:], uint16(i))
+		e.w.Write(buf[:3])
+	case i >= math.MinInt32:
+		buf[0] = 0xd2
+		binary.BigEndian.PutUint32(buf[1:], uint32(i))
+		e.w.Write(buf[:5])
+	default:
+		buf[0] = 0xd3
+		binary.BigEndian.PutUint64(buf[1:], uint64(i))
+		e.w.Write(buf[:9])
+	}
+}
@@ -0,0 +1,79 @@
+package libucl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestObjectEmitTo(t *testing.T) {
+	obj := testParseString(t, "foo = bar; bar = [1, 2.5, true];")
+	defer obj.Close()
+
+	for _, e := range []Emitter{EmitJSON, EmitJSONCompact, EmitConfig, EmitYAML} {
+		expected, err := obj.Emit(e)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		var buf bytes.Buffer
+		if err := obj.EmitTo(&buf, e); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if buf.String() != expected {
+			t.Fatalf("bad %d: %#v", e, buf.String())
+		}
+	}
+}
+
+func TestObjectEmitTo_writeError(t *testing.T) {
+	obj := testParseString(t, "foo = bar;")
+	defer obj.Close()
+
+	if err := obj.EmitTo(errWriter{}, EmitJSON); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestObjectEmitTo_concurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			obj, err := ParseString(fmt.Sprintf("foo = %d;", i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer obj.Close()
+
+			var buf bytes.Buffer
+			if err := obj.EmitTo(&buf, EmitJSONCompact); err != nil {
+				errs <- err
+				return
+			}
+
+			if expected := fmt.Sprintf(`{"foo":%d}`, i); buf.String() != expected {
+				errs <- fmt.Errorf("bad: %s", buf.String())
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -0,0 +1,374 @@
+package libucl
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// structField is a field that a struct is decoded into, found once per
+// type from its tags and embedded structs.
+type structField struct {
+	// index is the index sequence of the field, for FieldByIndex.
+	index []int
+
+	// name is the name of the Go field, and key is the key it is decoded
+	// from. tagged is true if the key was set in the tag.
+	name   string
+	key    string
+	tagged bool
+
+	// special is a tag option such as "key" or "unusedKeys" that makes
+	// the field hold information about decoding rather than a value.
+	special string
+
+	// append and replace are the "append" and "replace" tag options.
+	append  bool
+	replace bool
+}
+
+// structInfo is the decoding plan for a struct type.
+type structInfo struct {
+	fields []structField
+	err    error
+}
+
+// structCacheKey is a type along with the decoding options that change
+// its structInfo.
+type structCacheKey struct {
+	t      reflect.Type
+	squash bool
+	tags   string
+}
+
+// structCache caches the structInfo of each type decoded into, so that
+// tags are only parsed once.
+var structCache map[structCacheKey]*structInfo
+var structCacheLock sync.RWMutex
+
+func init() {
+	structCache = make(map[structCacheKey]*structInfo)
+}
+
+// cachedStructInfo returns the decoding plan for the struct type t with
+// the given options.
+func cachedStructInfo(t reflect.Type, opts *DecodeOptions) (*structInfo, error) {
+	tagNames := opts.TagNames
+	if len(tagNames) == 0 {
+		tagNames = []string{tagName}
+	}
+
+	key := structCacheKey{
+		t:      t,
+		squash: opts.SquashEmbedded,
+		tags:   strings.Join(tagNames, ","),
+	}
+
+	structCacheLock.RLock()
+	info, ok := structCache[key]
+	structCacheLock.RUnlock()
+	if !ok {
+		info = newStructInfo(t, opts.SquashEmbedded, tagNames)
+
+		structCacheLock.Lock()
+		structCache[key] = info
+		structCacheLock.Unlock()
+	}
+
+	return info, info.err
+}
+
+func newStructInfo(t reflect.Type, squashDefault bool, tagNames []string) *structInfo {
+	info := new(structInfo)
+
+	// Walk the struct and any embedded structs that are squashed into
+	// it, breadth first.
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+	structs := []embedded{{t: t}}
+	visited := map[reflect.Type]bool{t: true}
+	for len(structs) > 0 {
+		s := structs[0]
+		structs = structs[1:]
+
+		for i := 0; i < s.t.NumField(); i++ {
+			fieldType := s.t.Field(i)
+			tag := lookupTag(fieldType.Tag, tagNames)
+			if tag == "-" {
+				continue
+			}
+			tagParts := strings.Split(tag, ",")
+
+			index := make([]int, len(s.index)+1)
+			copy(index, s.index)
+			index[len(s.index)] = i
+
+			if fieldType.Anonymous {
+				embeddedType := fieldType.Type
+				if embeddedType.Kind() == reflect.Ptr {
+					embeddedType = embeddedType.Elem()
+				}
+				if embeddedType.Kind() != reflect.Struct {
+					info.err = fmt.Errorf(
+						"%s: unsupported type to struct: %s",
+						fieldType.Name, fieldType.Type.Kind())
+					return info
+				}
+
+				// We have an embedded field. We "squash" the fields down
+				// if specified in the tag, or by default if the tag
+				// doesn't give it a key.
+				squash := hasTagOption(tagParts, "squash") ||
+					(squashDefault && tagParts[0] == "")
+				if squash {
+					// A struct can embed a pointer to itself, which we
+					// can only squash once.
+					if visited[embeddedType] {
+						continue
+					}
+					visited[embeddedType] = true
+
+					structs = append(structs, embedded{
+						t:     embeddedType,
+						index: index,
+					})
+					continue
+				}
+			}
+
+			// If we can't set the field, then it is unexported or
+			// something, and we just continue onwards.
+			if fieldType.PkgPath != "" {
+				continue
+			}
+
+			field := structField{
+				index:   index,
+				name:    fieldType.Name,
+				key:     fieldType.Name,
+				append:  hasTagOption(tagParts, "append"),
+				replace: hasTagOption(tagParts, "replace"),
+			}
+			if len(tagParts) >= 2 {
+				switch tagParts[1] {
+				case "decodedFields", "key", "nullFields", "object", "unusedKeys":
+					field.special = tagParts[1]
+				}
+			}
+			if tagParts[0] != "" {
+				field.key = tagParts[0]
+				field.tagged = true
+			}
+
+			info.fields = append(info.fields, field)
+		}
+	}
+
+	info.fields = dominantFields(info.fields)
+	return info
+}
+
+// dominantFields removes the fields that are hidden by another field
+// with the same key, with the rules of encoding/json: the field that is
+// nested the least wins, and then a field whose key is tagged. If that
+// leaves more than one field, none of them are decoded.
+func dominantFields(fields []structField) []structField {
+	byKey := make(map[string][]int)
+	for i, f := range fields {
+		if f.special != "" {
+			continue
+		}
+
+		byKey[f.key] = append(byKey[f.key], i)
+	}
+
+	hidden := make(map[int]bool)
+	for _, group := range byKey {
+		if len(group) < 2 {
+			continue
+		}
+
+		winner, ok := dominantField(fields, group)
+		for _, i := range group {
+			if !ok || i != winner {
+				hidden[i] = true
+			}
+		}
+	}
+	if len(hidden) == 0 {
+		return fields
+	}
+
+	result := make([]structField, 0, len(fields)-len(hidden))
+	for i, f := range fields {
+		if !hidden[i] {
+			result = append(result, f)
+		}
+	}
+
+	return result
+}
+
+// dominantField returns the index of the field in group that hides the
+// others, or false if there isn't one.
+func dominantField(fields []structField, group []int) (int, bool) {
+	depth := -1
+	var shallowest []int
+	for _, i := range group {
+		d := len(fields[i].index)
+		switch {
+		case depth < 0 || d < depth:
+			depth = d
+			shallowest = []int{i}
+		case d == depth:
+			shallowest = append(shallowest, i)
+		}
+	}
+	if len(shallowest) == 1 {
+		return shallowest[0], true
+	}
+
+	var tagged []int
+	for _, i := range shallowest {
+		if fields[i].tagged {
+			tagged = append(tagged, i)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+
+	return 0, false
+}
+
+// lookupTag returns the first of the named tags that is set.
+func lookupTag(tag reflect.StructTag, names []string) string {
+	for _, name := range names {
+		if v, ok := tag.Lookup(name); ok {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// fieldByIndex returns the field of v with the given index sequence.
+// Nil embedded pointers along the way are allocated if alloc is true,
+// otherwise an invalid value is returned for them.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, nil
+				}
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf(
+						"cannot set embedded pointer to unexported struct: %s",
+						v.Type().Elem())
+				}
+
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+
+			v = v.Elem()
+		}
+
+		v = v.Field(x)
+	}
+
+	return v, nil
+}
+
+// mappedKeys returns the keys of the fields in info with the names of
+// untagged fields mapped by mapper. Fields hidden by another field with
+// the same key, by the rules of dominantFields, get an empty key. It is
+// an error for two fields to have the same key otherwise.
+func mappedKeys(info *structInfo, mapper NameMapper) ([]string, error) {
+	keys := make([]string, len(info.fields))
+	var order []string
+	byKey := make(map[string][]int)
+	for i, f := range info.fields {
+		keys[i] = f.key
+		if f.special != "" {
+			continue
+		}
+		if !f.tagged {
+			keys[i] = mapper(f.name)
+		}
+
+		if _, ok := byKey[keys[i]]; !ok {
+			order = append(order, keys[i])
+		}
+		byKey[keys[i]] = append(byKey[keys[i]], i)
+	}
+
+	for _, key := range order {
+		group := byKey[key]
+		if len(group) < 2 {
+			continue
+		}
+
+		winner, ok := dominantField(info.fields, group)
+		if !ok {
+			return nil, fmt.Errorf(
+				"fields %s and %s are both decoded from key '%s'",
+				info.fields[group[0]].name, info.fields[group[1]].name, key)
+		}
+		for _, i := range group {
+			if i != winner {
+				keys[i] = ""
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// keyIndex finds the keys of an object case-insensitively. The index
+// is only built the first time an exact lookup fails.
+type keyIndex struct {
+	o      *Object
+	strict bool
+	keys   map[string][]string
+}
+
+// get returns the value of key, or nil if it isn't set. If key isn't set
+// exactly, but more than one key matches it when ignoring case, the
+// first one is used, or if the index is strict it is an error.
+func (idx *keyIndex) get(key string) (*Object, error) {
+	if elem := idx.o.Get(key); elem != nil {
+		return elem, nil
+	}
+
+	if idx.keys == nil {
+		idx.keys = make(map[string][]string)
+
+		iter := idx.o.Iterate(true)
+		for elem := iter.Next(); elem != nil; elem = iter.Next() {
+			k := elem.Key()
+			lower := strings.ToLower(k)
+			idx.keys[lower] = append(idx.keys[lower], k)
+			elem.Close()
+		}
+		iter.Close()
+	}
+
+	matches := idx.keys[strings.ToLower(key)]
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return idx.o.Get(matches[0]), nil
+	default:
+		if !idx.strict {
+			return idx.o.Get(matches[0]), nil
+		}
+
+		return nil, fmt.Errorf(
+			"ambiguous keys for %s: %s", key, strings.Join(matches, ", "))
+	}
+}
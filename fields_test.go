@@ -0,0 +1,175 @@
+package libucl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCachedStructInfo(t *testing.T) {
+	type Embedded struct {
+		Port int
+	}
+
+	type Struct struct {
+		Embedded `libucl:",squash"`
+		Name     string   `libucl:"name"`
+		Hosts    []string `libucl:",append"`
+		Unused   []string `libucl:",unusedKeys"`
+		private  string
+	}
+
+	info, err := cachedStructInfo(reflect.TypeOf(Struct{}), new(DecodeOptions))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []structField{
+		{index: []int{1}, name: "Name", key: "name", tagged: true},
+		{index: []int{2}, name: "Hosts", key: "Hosts", append: true},
+		{index: []int{3}, name: "Unused", key: "Unused", special: "unusedKeys"},
+		{index: []int{0, 0}, name: "Port", key: "Port"},
+	}
+	if !reflect.DeepEqual(info.fields, expected) {
+		t.Fatalf("bad: %#v", info.fields)
+	}
+
+	info2, err := cachedStructInfo(reflect.TypeOf(Struct{}), new(DecodeOptions))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if info2 != info {
+		t.Fatal("should be cached")
+	}
+}
+
+func TestCachedStructInfo_dominance(t *testing.T) {
+	type Base struct {
+		Name  string
+		Port  int
+		Host  string `libucl:"Addr"`
+		Addr  string
+		Label string
+	}
+
+	type Other struct {
+		Label string
+	}
+
+	type Struct struct {
+		*Base
+		Other
+		Name string
+	}
+
+	opts := &DecodeOptions{SquashEmbedded: true}
+	info, err := cachedStructInfo(reflect.TypeOf(Struct{}), opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Name is hidden by the shallower field, Addr by the tagged field
+	// at the same depth, and both Labels are dropped.
+	expected := []structField{
+		{index: []int{2}, name: "Name", key: "Name"},
+		{index: []int{0, 1}, name: "Port", key: "Port"},
+		{index: []int{0, 2}, name: "Host", key: "Addr", tagged: true},
+	}
+	if !reflect.DeepEqual(info.fields, expected) {
+		t.Fatalf("bad: %#v", info.fields)
+	}
+}
+
+func TestCachedStructInfo_embeddedNonStruct(t *testing.T) {
+	type Embedded int
+	type Struct struct {
+		Embedded
+	}
+
+	if _, err := cachedStructInfo(reflect.TypeOf(Struct{}), new(DecodeOptions)); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestFieldByIndex(t *testing.T) {
+	type Base struct {
+		Name string
+	}
+
+	type Struct struct {
+		*Base `libucl:",squash"`
+	}
+
+	var result Struct
+	info, err := cachedStructInfo(reflect.TypeOf(result), new(DecodeOptions))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	v := reflect.ValueOf(&result).Elem()
+	field, err := fieldByIndex(v, info.fields[0].index, false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if field.IsValid() || result.Base != nil {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	field, err = fieldByIndex(v, info.fields[0].index, true)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	field.SetString("foo")
+	if result.Base == nil || result.Name != "foo" {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestCachedStructInfo_recursive(t *testing.T) {
+	type Struct struct {
+		*Struct
+		Name string
+	}
+
+	info, err := cachedStructInfo(reflect.TypeOf(Struct{}), &DecodeOptions{SquashEmbedded: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(info.fields) != 1 || info.fields[0].name != "Name" {
+		t.Fatalf("bad: %#v", info.fields)
+	}
+}
+
+func TestCachedStructInfo_tagNames(t *testing.T) {
+	type Struct struct {
+		Name    string `json:"name,omitempty"`
+		Port    int    `libucl:"port" json:"json_port"`
+		Skip    string `json:"-"`
+		Dash    string `json:"-,"`
+		Default string
+	}
+
+	opts := &DecodeOptions{TagNames: []string{"libucl", "json"}}
+	info, err := cachedStructInfo(reflect.TypeOf(Struct{}), opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var keys []string
+	for _, f := range info.fields {
+		keys = append(keys, f.key)
+	}
+
+	expected := []string{"name", "port", "-", "Default"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	// Without the fallback, the json tag is ignored
+	info, err = cachedStructInfo(reflect.TypeOf(Struct{}), new(DecodeOptions))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(info.fields) != 5 || info.fields[0].key != "Name" {
+		t.Fatalf("bad: %#v", info.fields)
+	}
+}
@@ -0,0 +1,367 @@
+// Package format implements canonical formatting of libucl configuration.
+//
+// Formatting parses the source with libucl and prints the resulting
+// objects back out in a single style: one key per line, a consistent
+// indent, and every string value quoted. Because the output is printed
+// from the parsed objects, formatting is idempotent: formatting
+// already-formatted source returns it unchanged.
+//
+// Formatting doesn't change what the source means: macros such as
+// .include aren't run but kept in place, variables aren't expanded, and
+// numbers are written as they are in the source, such as "10min" or
+// "512mb".
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/go-libucl"
+)
+
+// Options are the options for formatting. The zero value preserves the
+// key order and comments of the source, and indents with four spaces.
+type Options struct {
+	// SortKeys sorts the keys of every object. Repeated keys keep their
+	// relative order.
+	SortKeys bool
+
+	// StripComments removes comments rather than preserving them.
+	StripComments bool
+
+	// Indent is the string used for each level of indentation. If empty,
+	// four spaces are used.
+	Indent string
+}
+
+// bareKey matches keys that don't need to be quoted.
+var bareKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_\-]*$`)
+
+// keyValue matches a bare or quoted key and the separator before its
+// value. The first group is the key if it is bare.
+var keyValue = regexp.MustCompile(
+	`^(?:([^\s=:{\["'#;,]+)|"(?:[^"\\\n]|\\.)*"|'(?:[^'\\]|\\.)*')[ \t]*[=:]?[ \t]*`)
+
+// numberAtom matches a number and its suffix.
+var numberAtom = regexp.MustCompile(`^[-+]?\.?[0-9][0-9A-Za-z.+\-]*`)
+
+// Format parses the UCL source and returns it in canonical form.
+func Format(src []byte, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+
+	// Skipped macros are saved as comments, so comments are always
+	// saved, and positions are saved to find numbers in the source.
+	p := libucl.NewParser(libucl.ParserSaveComments |
+		libucl.ParserSavePositions | libucl.ParserDisableMacro |
+		libucl.ParserNoFileVars)
+	defer p.Close()
+	if err := p.AddString(string(src)); err != nil {
+		return nil, err
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		return nil, nil
+	}
+	defer obj.Close()
+
+	var buf bytes.Buffer
+	if err := fprint(&buf, obj, opts, src); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Fprint prints the top-level object obj in canonical form to w. Since
+// the source isn't known, numbers are written as libucl normalized them,
+// so "512mb" is written as 536870912.
+func Fprint(w io.Writer, obj *libucl.Object, opts *Options) error {
+	return fprint(w, obj, opts, nil)
+}
+
+func fprint(w io.Writer, obj *libucl.Object, opts *Options, src []byte) error {
+	if opts == nil {
+		opts = new(Options)
+	}
+	if obj.Type() != libucl.ObjectTypeObject {
+		return fmt.Errorf("top-level value must be an object, got: %d", obj.Type())
+	}
+
+	p := &printer{
+		w:      bufio.NewWriter(w),
+		opts:   opts,
+		indent: opts.Indent,
+		src:    src,
+	}
+	if p.indent == "" {
+		p.indent = "    "
+	}
+
+	if err := p.printObject(obj, 0); err != nil {
+		return err
+	}
+
+	return p.w.Flush()
+}
+
+type printer struct {
+	w      *bufio.Writer
+	opts   *Options
+	indent string
+
+	// src is the source the objects were parsed from, if known, and
+	// lines are the offsets of the start of each of its lines.
+	src   []byte
+	lines []int
+}
+
+// printObject prints the keys and values of obj, each line indented
+// by depth.
+func (p *printer) printObject(obj *libucl.Object, depth int) error {
+	// Collect every key. Each one may have multiple values if the key
+	// was repeated.
+	var elems []*libucl.Object
+	iter := obj.Iterate(true)
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		elems = append(elems, elem)
+	}
+	iter.Close()
+	defer func() {
+		for _, elem := range elems {
+			elem.Close()
+		}
+	}()
+
+	if p.opts.SortKeys {
+		sort.SliceStable(elems, func(i, j int) bool {
+			return elems[i].Key() < elems[j].Key()
+		})
+	}
+
+	for _, elem := range elems {
+		key := quoteKey(elem.Key())
+
+		values := elem.Iterate(false)
+		for v := values.Next(); v != nil; v = values.Next() {
+			p.printComments(v, depth)
+			p.printIndent(depth)
+			p.w.WriteString(key)
+
+			var err error
+			switch v.Type() {
+			case libucl.ObjectTypeObject, libucl.ObjectTypeArray:
+				p.w.WriteString(" ")
+				err = p.printValue(v, depth)
+			default:
+				p.w.WriteString(" = ")
+				err = p.printValue(v, depth)
+				p.w.WriteString(";")
+			}
+			p.printLineEnd(v, depth)
+			v.Close()
+
+			if err != nil {
+				values.Close()
+				return err
+			}
+		}
+		values.Close()
+	}
+
+	return nil
+}
+
+// printValue prints a single value. Objects and arrays are printed across
+// multiple lines, with their contents indented by depth+1.
+func (p *printer) printValue(v *libucl.Object, depth int) error {
+	switch v.Type() {
+	case libucl.ObjectTypeObject:
+		p.w.WriteString("{\n")
+		if err := p.printObject(v, depth+1); err != nil {
+			return err
+		}
+		p.printIndent(depth)
+		p.w.WriteString("}")
+	case libucl.ObjectTypeArray:
+		if v.Len() == 0 {
+			p.w.WriteString("[]")
+			return nil
+		}
+
+		// Collect the elements first, so that the comma after every
+		// element but the last comes before its line comment.
+		var elems []*libucl.Object
+		iter := v.Iterate(true)
+		for elem := iter.Next(); elem != nil; elem = iter.Next() {
+			elems = append(elems, elem)
+		}
+		iter.Close()
+		defer func() {
+			for _, elem := range elems {
+				elem.Close()
+			}
+		}()
+
+		p.w.WriteString("[\n")
+		for i, elem := range elems {
+			p.printComments(elem, depth+1)
+			p.printIndent(depth + 1)
+			if err := p.printValue(elem, depth+1); err != nil {
+				return err
+			}
+			if i < len(elems)-1 {
+				p.w.WriteString(",")
+			}
+			p.printLineEnd(elem, depth+1)
+		}
+		p.printIndent(depth)
+		p.w.WriteString("]")
+	case libucl.ObjectTypeUserData:
+		return fmt.Errorf("%s: unsupported type: %d", v.Key(), v.Type())
+	default:
+		if s, ok := p.sourceNumber(v); ok {
+			p.w.WriteString(s)
+			return nil
+		}
+
+		// Scalars are printed by libucl so that they are quoted and
+		// escaped exactly as libucl expects to read them back.
+		s, err := v.Emit(libucl.EmitJSONCompact)
+		if err != nil {
+			return err
+		}
+		p.w.WriteString(s)
+	}
+
+	return nil
+}
+
+func (p *printer) printComments(v *libucl.Object, depth int) {
+	for _, c := range v.Comments() {
+		if !p.keepComment(c) {
+			continue
+		}
+
+		p.printIndent(depth)
+		p.w.WriteString(c)
+		p.w.WriteString("\n")
+	}
+}
+
+// printLineEnd ends the line that v was printed on, with its line
+// comment, and prints the comments that come after it.
+func (p *printer) printLineEnd(v *libucl.Object, depth int) {
+	if c := v.LineComment(); c != "" && p.keepComment(c) {
+		p.w.WriteString(" ")
+		p.w.WriteString(c)
+	}
+	p.w.WriteString("\n")
+
+	for _, c := range v.TrailingComments() {
+		if !p.keepComment(c) {
+			continue
+		}
+
+		p.printIndent(depth)
+		p.w.WriteString(c)
+		p.w.WriteString("\n")
+	}
+}
+
+// keepComment returns true if the comment c is printed. Macros, which
+// the parser saves as comments, are always printed.
+func (p *printer) keepComment(c string) bool {
+	return !p.opts.StripComments || strings.HasPrefix(c, ".")
+}
+
+// sourceNumber returns the number v as it is written in the source, such
+// as 10min or 512mb, if it can be found there.
+func (p *printer) sourceNumber(v *libucl.Object) (string, bool) {
+	switch v.Type() {
+	case libucl.ObjectTypeInt, libucl.ObjectTypeFloat, libucl.ObjectTypeTime:
+	default:
+		return "", false
+	}
+
+	pos := v.Position()
+	if p.src == nil || !pos.IsValid() || pos.Filename != "" {
+		return "", false
+	}
+
+	if p.lines == nil {
+		p.lines = []int{0}
+		for i, c := range p.src {
+			if c == '\n' {
+				p.lines = append(p.lines, i+1)
+			}
+		}
+	}
+	if pos.Line > len(p.lines) {
+		return "", false
+	}
+	off := p.lines[pos.Line-1] + pos.Column - 1
+	if off >= len(p.src) {
+		return "", false
+	}
+
+	// The position of a value of a key is the position of the key.
+	rest := p.src[off:]
+	if key := v.Key(); key != "" {
+		m := keyValue.FindSubmatch(rest)
+		if m == nil || (m[1] != nil && string(m[1]) != key) {
+			return "", false
+		}
+		rest = rest[len(m[0]):]
+	}
+
+	num := numberAtom.Find(rest)
+	if num == nil {
+		return "", false
+	}
+
+	// The number is only used if it is read back as the same value,
+	// in case the position is wrong.
+	obj, err := libucl.ParseString("n = " + string(num))
+	if err != nil {
+		return "", false
+	}
+	defer obj.Close()
+
+	n := obj.Get("n")
+	if n == nil {
+		return "", false
+	}
+	defer n.Close()
+
+	if n.Type() != v.Type() || n.ToFloat() != v.ToFloat() || n.ToInt() != v.ToInt() {
+		return "", false
+	}
+
+	return string(num), true
+}
+
+func (p *printer) printIndent(depth int) {
+	p.w.WriteString(strings.Repeat(p.indent, depth))
+}
+
+// quoteKey returns the key as it should be written, quoting it if it
+// can't be written bare.
+func quoteKey(k string) string {
+	if bareKey.MatchString(k) {
+		return k
+	}
+
+	obj := libucl.NewString(k)
+	defer obj.Close()
+
+	s, _ := obj.Emit(libucl.EmitJSONCompact)
+	return s
+}
@@ -0,0 +1,198 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mitchellh/go-libucl"
+)
+
+const testSource = `
+# Listener
+listen = "0.0.0.0:80";
+b { y = 2; x = true; }
+"a key" = [1, two];
+`
+
+func TestFormat(t *testing.T) {
+	result, err := Format([]byte(testSource), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `# Listener
+listen = "0.0.0.0:80";
+b {
+    y = 2;
+    x = true;
+}
+"a key" [
+    1,
+    "two"
+]
+`
+	if string(result) != expected {
+		t.Fatalf("bad: %#v", string(result))
+	}
+}
+
+func TestFormat_idempotent(t *testing.T) {
+	for _, opts := range []*Options{
+		nil,
+		{SortKeys: true},
+		{StripComments: true, Indent: "\t"},
+	} {
+		first, err := Format([]byte(testSource), opts)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		second, err := Format(first, opts)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if string(first) != string(second) {
+			t.Fatalf("bad: %#v\n\n%#v", string(first), string(second))
+		}
+	}
+}
+
+func TestFormat_invalid(t *testing.T) {
+	if _, err := Format([]byte(`foo = {`), nil); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestFormat_options(t *testing.T) {
+	opts := &Options{
+		SortKeys:      true,
+		StripComments: true,
+		Indent:        "\t",
+	}
+
+	result, err := Format([]byte(testSource), opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "\"a key\" [\n\t1,\n\t\"two\"\n]\n" +
+		"b {\n\tx = true;\n\ty = 2;\n}\n" +
+		"listen = \"0.0.0.0:80\";\n"
+	if string(result) != expected {
+		t.Fatalf("bad: %#v", string(result))
+	}
+}
+
+func TestFormat_repeatedKeys(t *testing.T) {
+	result, err := Format([]byte(`foo = bar; baz = 1; foo = qux;`), &Options{
+		SortKeys: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "baz = 1;\nfoo = \"bar\";\nfoo = \"qux\";\n"
+	if string(result) != expected {
+		t.Fatalf("bad: %#v", string(result))
+	}
+}
+
+func TestFormat_trailingComments(t *testing.T) {
+	src := "a = 1;\nb {\n    x = 1;\n    # end of b\n}\n# end of file\n"
+
+	result, err := Format([]byte(src), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(result) != src {
+		t.Fatalf("bad: %#v", string(result))
+	}
+}
+
+func TestFormat_lineComments(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			"a = 1; # about a\nb = 2;\n# end of file\n",
+			"a = 1; # about a\nb = 2;\n# end of file\n",
+		},
+		{
+			"a = 1;\n# about b\nb { x = 1 }\n",
+			"a = 1;\n# about b\nb {\n    x = 1;\n}\n",
+		},
+		{
+			"a = [1, # one\n 2 # two\n]\n",
+			"a [\n    1, # one\n    2 # two\n]\n",
+		},
+	}
+
+	for _, tc := range cases {
+		result, err := Format([]byte(tc.Input), nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(result) != tc.Expected {
+			t.Fatalf("input: %s\n\nbad: %#v", tc.Input, string(result))
+		}
+	}
+}
+
+func TestFormat_source(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Opts     *Options
+		Expected string
+	}{
+		{
+			".include \"/nonexistent/inc.conf\"\na = 1;\n",
+			nil,
+			".include \"/nonexistent/inc.conf\"\na = 1;\n",
+		},
+		{
+			"# about a\n.priority 2\na = 1;\n",
+			&Options{StripComments: true},
+			".priority 2\na = 1;\n",
+		},
+		{
+			"path = \"$CURDIR/x\";\n",
+			nil,
+			"path = \"$CURDIR/x\";\n",
+		},
+		{
+			"size = 512mb; timeout = 10s; n = [1k, 0x10, 1.5];\n",
+			nil,
+			"size = 512mb;\ntimeout = 10s;\nn [\n    1k,\n    0x10,\n    1.5\n]\n",
+		},
+	}
+
+	for _, tc := range cases {
+		result, err := Format([]byte(tc.Input), tc.Opts)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(result) != tc.Expected {
+			t.Fatalf("input: %s\n\nbad: %#v", tc.Input, string(result))
+		}
+	}
+}
+
+func TestFprint_numbers(t *testing.T) {
+	obj, err := libucl.ParseString("size = 512mb;")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	var buf bytes.Buffer
+	if err := Fprint(&buf, obj, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if buf.String() != "size = 536870912;\n" {
+		t.Fatalf("bad: %#v", buf.String())
+	}
+}
@@ -0,0 +1,206 @@
+package libucl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The fuzz targets are seeded with the inputs of the golden and
+// conformance tests, along with the inputs below. Inputs that once
+// crashed or failed are checked in to testdata/fuzz, so that they're run
+// as regression tests by go test.
+
+var fuzzSeeds = []string{
+	"",
+	"foo = bar;",
+	"a = 1k; b = 10min; c = 1.5; d = yes; e = null;",
+	`a "b" { c = [1, 2, {d = 3}]; }`,
+	"x = <<EOD\nfoo\nbar\nEOD\n",
+	"/* a /* b */ */ k = 'v\\'';",
+	"# comment\nk = v # comment\n",
+	`{"foo": [1, 2.5, "x", true, null], "bar": {}}`,
+	`[1, {a = 2}, "x"]`,
+	"key = $NAME; other = \"${NAME}\";",
+	"foo { bar = 1 }\nfoo { bar = 2 }\n",
+	"foo = 1; foo = 2; foo { bar = baz }",
+	"foo = { bar = [[[[[[1]]]]]] }",
+	`"key with spaces" = "value é\n\t";`,
+}
+
+func fuzzAddSeeds(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+
+	for _, pattern := range []string{
+		filepath.Join("testdata", "golden", "*.in"),
+		filepath.Join("testdata", "conformance", "*.ucl"),
+	} {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			f.Fatalf("err: %s", err)
+		}
+		for _, path := range paths {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				f.Fatalf("err: %s", err)
+			}
+			f.Add(string(data))
+		}
+	}
+}
+
+// fuzzParse parses input the same way as an application would.
+func fuzzParse(input string) (*Object, error) {
+	p := NewParser(0)
+	defer p.Close()
+
+	p.RegisterVariable("NAME", "libucl")
+	if err := p.AddString(input); err != nil {
+		return nil, err
+	}
+
+	return p.Object(), nil
+}
+
+func FuzzParseString(f *testing.F) {
+	fuzzAddSeeds(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		obj, err := fuzzParse(input)
+		if err != nil {
+			return
+		}
+		defer obj.Close()
+
+		// Walk the whole object, so that every value is read back from
+		// the parser.
+		testObjectValue(obj, true)
+	})
+}
+
+// fuzzConfig is a representative configuration, covering each kind of
+// value that can be decoded into.
+type fuzzConfig struct {
+	Name     string
+	Port     int
+	Enabled  bool
+	Ratio    float64
+	Size     ByteSize
+	Timeout  time.Duration
+	Tags     []string
+	Ports    [2]uint16
+	Labels   map[string]string
+	Any      interface{}
+	Ptr      *fuzzServer
+	Servers  []fuzzServer `libucl:"server"`
+	Nested   map[string][]map[string]interface{}
+	Embedded fuzzEmbedded `libucl:",squash"`
+	Unused   []string     `libucl:",unusedKeys"`
+}
+
+type fuzzServer struct {
+	Key     string `libucl:",key"`
+	Address string
+	Weight  float32
+	Backup  *bool
+}
+
+type fuzzEmbedded struct {
+	Debug bool
+	Level int8
+}
+
+func FuzzDecode(f *testing.F) {
+	fuzzAddSeeds(f)
+	f.Add(`
+	name = web; port = 8080; enabled = yes; ratio = 0.5; size = 1mb;
+	timeout = 30s; tags = [a, b]; ports = [80, 443];
+	labels { env = prod; }
+	any = [1, {a = b}];
+	ptr { address = "10.0.0.1"; }
+	server "a" { address = "10.0.0.2"; weight = 1.5; backup = true; }
+	server "b" { address = "10.0.0.3"; }
+	nested { x = [{y = 1}] }
+	debug = true; level = 300; unknown = 1;
+	`)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		obj, err := fuzzParse(input)
+		if err != nil {
+			return
+		}
+		defer obj.Close()
+
+		var result fuzzConfig
+		obj.Decode(&result)
+
+		var any interface{}
+		obj.Decode(&any)
+	})
+}
+
+func FuzzEmitRoundTrip(f *testing.F) {
+	fuzzAddSeeds(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		obj, err := fuzzParse(input)
+		if err != nil {
+			return
+		}
+		defer obj.Close()
+
+		expected, err := obj.Emit(EmitJSONCompact)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		for _, e := range goldenEmitters {
+			data, err := obj.Emit(e.Emitter)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !e.Parse {
+				continue
+			}
+
+			p := NewParser(0)
+			if e.Emitter == EmitMsgpack {
+				err = p.AddMsgpack([]byte(data))
+			} else {
+				err = p.AddString(data)
+			}
+			if err != nil {
+				p.Close()
+				t.Fatalf("%s: err: %s\n\n%s", e.Ext, err, data)
+			}
+			obj2 := p.Object()
+			p.Close()
+
+			// Floats are written with limited precision as text, so text
+			// is checked by emitting the parsed object again. Only the
+			// first value of an implicit array is written as msgpack.
+			if e.Emitter == EmitMsgpack {
+				actual := testObjectValue(obj2, false)
+				if !reflect.DeepEqual(actual, testObjectValue(obj, false)) {
+					obj2.Close()
+					t.Fatalf("%s: bad: %#v\n\n%q", e.Ext, actual, data)
+				}
+			} else {
+				actual, err := obj2.Emit(EmitJSONCompact)
+				if err != nil {
+					obj2.Close()
+					t.Fatalf("err: %s", err)
+				}
+				if actual != expected {
+					obj2.Close()
+					t.Fatalf("%s: bad: %s\n\nexpected: %s\n\n%s", e.Ext, actual, expected, data)
+				}
+			}
+			obj2.Close()
+		}
+	})
+}
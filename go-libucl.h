@@ -2,23 +2,66 @@
 #define _GOLIBUCL_H_INCLUDED
 
 #include <ucl.h>
+#include <stdint.h>
 #include <stdlib.h>
 
 static inline char *_go_uchar_to_char(const unsigned char *c) {
     return (char *)c;
 }
 
+//-------------------------------------------------------------------
+// Helpers: Emitters
+//-------------------------------------------------------------------
+
+// These are declared in emitter.go and write the emitted output to the
+// Go writer for a specific emit (specified by its cgo.Handle).
+extern int go_emit_character(uintptr_t, unsigned char c, size_t nchars);
+extern int go_emit_len(uintptr_t, unsigned char *str, size_t len);
+extern int go_emit_int(uintptr_t, int64_t elt);
+extern int go_emit_double(uintptr_t, double elt);
+
+// Indirections that actually call the Go emitter functions.
+static inline int _go_emit_character(unsigned char c, size_t nchars, void *ud) {
+    return go_emit_character((uintptr_t)ud, c, nchars);
+}
+
+static inline int _go_emit_len(const unsigned char *str, size_t len, void *ud) {
+    return go_emit_len((uintptr_t)ud, (unsigned char *)str, len);
+}
+
+static inline int _go_emit_int(int64_t elt, void *ud) {
+    return go_emit_int((uintptr_t)ud, elt);
+}
+
+static inline int _go_emit_double(double elt, void *ud) {
+    return go_emit_double((uintptr_t)ud, elt);
+}
+
+// Returns the emitter functions that write to the Go writer with the
+// given cgo.Handle.
+static inline struct ucl_emitter_functions _go_emitter_functions(uintptr_t h) {
+    struct ucl_emitter_functions f;
+
+    f.ucl_emitter_append_character = &_go_emit_character;
+    f.ucl_emitter_append_len = &_go_emit_len;
+    f.ucl_emitter_append_int = &_go_emit_int;
+    f.ucl_emitter_append_double = &_go_emit_double;
+    f.ucl_emitter_free_func = NULL;
+    f.ud = (void *)h;
+    return f;
+}
+
 //-------------------------------------------------------------------
 // Helpers: Macros
 //-------------------------------------------------------------------
 
 // This is declared in parser.go and invokes the Go function callback for
-// a specific macro (specified by the ID).
-extern bool go_macro_call(int, char *data, int);
+// a specific macro (specified by its cgo.Handle).
+extern bool go_macro_call(uintptr_t, char *data, int);
 
 // Indirection that actually calls the Go macro handler.
 static inline bool _go_macro_handler(const unsigned char *data, size_t len, void* ud) {
-    return go_macro_call((int)ud, (char*)data, (int)len);
+    return go_macro_call((uintptr_t)ud, (char*)data, (int)len);
 }
 
 // Returns the ucl_macro_handler that we have, since we can't get this
@@ -27,10 +70,10 @@ static inline ucl_macro_handler _go_macro_handler_func() {
     return &_go_macro_handler;
 }
 
-// This just converts an int to a void*, because Go doesn't let us do that
-// and we use an int as the user data for registering macros.
-static inline void *_go_macro_index(int idx) {
-    return (void *)idx;
+// This just converts a cgo.Handle to a void*, because Go doesn't let us
+// do that and we use the handle as the user data for registering macros.
+static inline void *_go_macro_handle(uintptr_t h) {
+    return (void *)h;
 }
 
 #endif /* _GOLIBUCL_H_INCLUDED */
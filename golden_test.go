@@ -0,0 +1,172 @@
+package libucl
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The golden tests parse each .in file in testdata/golden, emit it in
+// every format and compare the output with the golden file for that
+// format. Run the tests with -update to rewrite the golden files, such as
+// after updating libucl, and review the diff.
+var update = flag.Bool("update", false, "update the golden files")
+
+// goldenEmitters are the emitters that are tested, along with the
+// extension of their golden files and whether their output can be parsed
+// back.
+var goldenEmitters = []struct {
+	Emitter Emitter
+	Ext     string
+	Parse   bool
+}{
+	{EmitJSON, ".json", true},
+	{EmitJSONCompact, ".compact.json", true},
+	{EmitConfig, ".conf", true},
+	{EmitYAML, ".yaml", false},
+	{EmitMsgpack, ".msgpack", true},
+}
+
+func TestGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "golden", "*.in"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no golden tests")
+	}
+
+	for _, path := range paths {
+		obj := testParseFile(t, path)
+
+		for _, e := range goldenEmitters {
+			actual, err := obj.Emit(e.Emitter)
+			if err != nil {
+				t.Fatalf("%s: err: %s", path, err)
+			}
+
+			golden := strings.TrimSuffix(path, ".in") + e.Ext
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(actual), 0644); err != nil {
+					t.Fatalf("err: %s", err)
+				}
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if actual != string(expected) {
+				t.Fatalf("%s: bad: %q\n\nexpected: %q", golden, actual, expected)
+			}
+
+			if e.Parse {
+				testRoundTrip(t, golden, obj, e.Emitter, actual)
+			}
+		}
+
+		obj.Close()
+	}
+}
+
+func testParseFile(t testing.TB, path string) *Object {
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddFile(path); err != nil {
+		t.Fatalf("%s: err: %s", path, err)
+	}
+
+	return p.Object()
+}
+
+// testRoundTrip checks that data, which is obj emitted with e, parses
+// back into the same values as obj.
+func testRoundTrip(t testing.TB, name string, obj *Object, e Emitter, data string) {
+	p := NewParser(0)
+	defer p.Close()
+
+	var err error
+	if e == EmitMsgpack {
+		err = p.AddMsgpack([]byte(data))
+	} else {
+		err = p.AddString(data)
+	}
+	if err != nil {
+		t.Fatalf("%s: round trip: err: %s\n\n%s", name, err, data)
+	}
+
+	obj2 := p.Object()
+	defer obj2.Close()
+
+	// Only the first value of an implicit array is written as msgpack.
+	implicit := e != EmitMsgpack
+	expected := testObjectValue(obj, implicit)
+	actual := testObjectValue(obj2, implicit)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("%s: round trip: bad: %#v\n\nexpected: %#v", name, actual, expected)
+	}
+}
+
+// testObjectValue converts an object to plain Go values so that objects
+// can be compared. Implicit arrays are converted the same as arrays, or
+// to their first value if implicit is false. Times are converted the same
+// as floats, since they are written as floats.
+func testObjectValue(o *Object, implicit bool) interface{} {
+	if implicit {
+		if values := testImplicitArray(o); len(values) > 1 {
+			return values
+		}
+	}
+
+	return testSingleValue(o, implicit)
+}
+
+func testImplicitArray(o *Object) []interface{} {
+	var result []interface{}
+
+	iter := o.Iterate(false)
+	defer iter.Close()
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		result = append(result, testSingleValue(elem, true))
+		elem.Close()
+	}
+
+	return result
+}
+
+func testSingleValue(o *Object, implicit bool) interface{} {
+	switch o.Type() {
+	case ObjectTypeObject:
+		result := make(map[string]interface{})
+		iter := o.Iterate(true)
+		defer iter.Close()
+		for elem := iter.Next(); elem != nil; elem = iter.Next() {
+			result[elem.Key()] = testObjectValue(elem, implicit)
+			elem.Close()
+		}
+		return result
+	case ObjectTypeArray:
+		result := make([]interface{}, 0)
+		iter := o.Iterate(true)
+		defer iter.Close()
+		for elem := iter.Next(); elem != nil; elem = iter.Next() {
+			result = append(result, testObjectValue(elem, implicit))
+			elem.Close()
+		}
+		return result
+	case ObjectTypeInt:
+		return o.ToInt()
+	case ObjectTypeFloat, ObjectTypeTime:
+		return o.ToFloat()
+	case ObjectTypeString:
+		return o.ToString()
+	case ObjectTypeBoolean:
+		return o.ToBool()
+	default:
+		return nil
+	}
+}
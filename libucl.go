@@ -1,3 +1,6 @@
+//go:build !purego
+// +build !purego
+
 package libucl
 
 // #cgo CFLAGS: -Ivendor/libucl/include -Wno-int-to-void-pointer-cast
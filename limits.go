@@ -0,0 +1,356 @@
+package libucl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// ParserOptions are options for a parser created with
+// NewParserWithOptions. The limits are for parsing configurations that
+// aren't trusted, and apply across everything given to the parser,
+// including included files. A limit of zero means there is no limit.
+//
+// libucl can't be stopped once it has started parsing, so with cgo each
+// chunk, and the files it includes or loads, are read and scanned for
+// MaxBytes, MaxDepth and MaxIncludeDepth before the chunk is given to
+// libucl. An include or .load that can't be followed, such as a file that
+// can't be read or a path with an unknown variable, returns a LimitError
+// for LimitInclude rather than being left to libucl, even with
+// try_include. Included files are read again by libucl after they are
+// checked. MaxObjects is only checked against the objects libucl
+// created once it is done, so it doesn't limit the memory libucl uses to
+// parse a chunk, which is bounded by MaxBytes.
+// MaxDepth and MaxObjects aren't checked by AddMsgpack.
+type ParserOptions struct {
+	// Flags are the flags the parser is created with.
+	Flags ParserFlag
+
+	// MaxBytes is the most bytes the parser reads.
+	MaxBytes int64
+
+	// MaxDepth is how deeply values can be nested in objects and
+	// arrays. The keys of the top object have a depth of 1.
+	MaxDepth int
+
+	// MaxObjects is the most values the parser creates, counting
+	// objects and arrays but not the top object.
+	MaxObjects int
+
+	// MaxIncludeDepth is how deeply includes can be nested. Includes
+	// are never followed more than 16 deep, so zero is the same as 16.
+	MaxIncludeDepth int
+
+	// Context stops parsing once it is canceled or its deadline passes.
+	// With cgo, it is only checked before and after libucl parses a
+	// chunk, so it doesn't stop libucl itself.
+	Context context.Context
+
+	// Timeout is how long each call that adds data may take. The same as
+	// Context, it doesn't cover the time libucl takes with cgo.
+	Timeout time.Duration
+}
+
+// NewParserWithOptions returns a parser with the given options. If opts
+// is nil, the defaults are used.
+func NewParserWithOptions(opts *ParserOptions) *Parser {
+	if opts == nil {
+		opts = new(ParserOptions)
+	}
+
+	p := NewParser(opts.Flags)
+	p.limits = &parseLimits{opts: *opts}
+
+	return p
+}
+
+// Limit is one of the limits in ParserOptions.
+type Limit int
+
+const (
+	// LimitBytes is ParserOptions.MaxBytes.
+	LimitBytes Limit = iota
+
+	// LimitDepth is ParserOptions.MaxDepth.
+	LimitDepth
+
+	// LimitObjects is ParserOptions.MaxObjects.
+	LimitObjects
+
+	// LimitIncludeDepth is ParserOptions.MaxIncludeDepth.
+	LimitIncludeDepth
+
+	// LimitContext is ParserOptions.Context and ParserOptions.Timeout.
+	LimitContext
+
+	// LimitInclude is an include or .load that can't be checked
+	// against the other limits before libucl parses it. It is only
+	// used with cgo.
+	LimitInclude
+)
+
+// LimitError is returned by a parser when the data it is given exceeds
+// one of its limits. The parser can't be used once it has returned a
+// LimitError.
+type LimitError struct {
+	Limit Limit
+
+	// Max is the limit that was exceeded. It is 0 for LimitContext and
+	// LimitInclude.
+	Max int64
+
+	// Pos is where the limit was exceeded, if it is known.
+	Pos Position
+
+	// Err is the error of the context for LimitContext, and why the
+	// include couldn't be checked for LimitInclude.
+	Err error
+}
+
+func (e *LimitError) Error() string {
+	var msg string
+	switch e.Limit {
+	case LimitBytes:
+		msg = fmt.Sprintf("data is longer than %d bytes", e.Max)
+	case LimitDepth:
+		msg = fmt.Sprintf("values are nested more than %d deep", e.Max)
+	case LimitObjects:
+		msg = fmt.Sprintf("data has more than %d values", e.Max)
+	case LimitIncludeDepth:
+		msg = fmt.Sprintf("includes are nested more than %d deep", e.Max)
+	case LimitInclude:
+		msg = fmt.Sprintf("include can't be checked: %s", e.Err)
+	default:
+		msg = fmt.Sprintf("parsing stopped: %s", e.Err)
+	}
+
+	if !e.Pos.IsValid() {
+		return msg
+	}
+
+	return fmt.Sprintf("%s: %s", e.Pos, msg)
+}
+
+// Unwrap returns Err, so that errors.Is can be used with
+// context.Canceled and context.DeadlineExceeded.
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// maxIncludeDepth is how deeply includes are followed at most.
+const maxIncludeDepth = 16
+
+// contextCheckInterval is how many values are created between checks of
+// the context, since checking it isn't free.
+const contextCheckInterval = 1024
+
+// parseLimits keeps track of what a parser has read against its limits.
+// The methods can be called on a nil parseLimits, for a parser without
+// limits, and do nothing.
+type parseLimits struct {
+	opts    ParserOptions
+	ctx     context.Context
+	bytes   int64
+	objects int
+	err     error
+}
+
+// start starts a call that adds data. The returned function must be
+// called once it is done.
+func (l *parseLimits) start() (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if l.err != nil {
+		return func() {}, l.err
+	}
+
+	l.ctx = l.opts.Context
+	if l.ctx == nil {
+		l.ctx = context.Background()
+	}
+
+	cancel := func() {}
+	if l.opts.Timeout > 0 {
+		l.ctx, cancel = context.WithTimeout(l.ctx, l.opts.Timeout)
+	}
+
+	return cancel, l.checkContext(Position{})
+}
+
+// failed returns the first limit that was exceeded, if any.
+func (l *parseLimits) failed() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.err
+}
+
+func (l *parseLimits) fail(limit Limit, max int64, pos Position) error {
+	if l.err == nil {
+		l.err = &LimitError{Limit: limit, Max: max, Pos: pos}
+	}
+
+	return l.err
+}
+
+// failInclude fails for an include that can't be checked because of err.
+func (l *parseLimits) failInclude(err error, pos Position) error {
+	if l.err == nil {
+		l.err = &LimitError{Limit: LimitInclude, Pos: pos, Err: err}
+	}
+
+	return l.err
+}
+
+func (l *parseLimits) checkContext(pos Position) error {
+	if l == nil || l.ctx == nil {
+		return nil
+	}
+
+	if err := l.ctx.Err(); err != nil {
+		if l.err == nil {
+			l.err = &LimitError{Limit: LimitContext, Pos: pos, Err: err}
+		}
+		return l.err
+	}
+
+	return nil
+}
+
+// addBytes counts n bytes read by the parser.
+func (l *parseLimits) addBytes(n int, pos Position) error {
+	if l == nil {
+		return nil
+	}
+
+	l.bytes += int64(n)
+	if l.opts.MaxBytes > 0 && l.bytes > l.opts.MaxBytes {
+		return l.fail(LimitBytes, l.opts.MaxBytes, pos)
+	}
+
+	return l.checkContext(pos)
+}
+
+// checkDepth checks a value at the given depth.
+func (l *parseLimits) checkDepth(depth int, pos Position) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.opts.MaxDepth > 0 && depth > l.opts.MaxDepth {
+		return l.fail(LimitDepth, int64(l.opts.MaxDepth), pos)
+	}
+
+	return nil
+}
+
+// addValue counts a value at the given depth.
+func (l *parseLimits) addValue(depth int, pos Position) error {
+	if l == nil {
+		return nil
+	}
+
+	if err := l.checkDepth(depth, pos); err != nil {
+		return err
+	}
+
+	l.objects++
+	if l.opts.MaxObjects > 0 && l.objects > l.opts.MaxObjects {
+		return l.fail(LimitObjects, int64(l.opts.MaxObjects), pos)
+	}
+
+	if l.objects%contextCheckInterval == 0 {
+		return l.checkContext(pos)
+	}
+
+	return nil
+}
+
+// countValues counts every value below o, which is at the given depth.
+func (l *parseLimits) countValues(o *Object, depth int) error {
+	switch o.Type() {
+	case ObjectTypeObject:
+		iter := o.Iterate(true)
+		defer iter.Close()
+		for elem := iter.Next(); elem != nil; elem = iter.Next() {
+			values := elem.Iterate(false)
+			for v := values.Next(); v != nil; v = values.Next() {
+				err := l.addValue(depth+1, v.Position())
+				if err == nil {
+					err = l.countValues(v, depth+1)
+				}
+				v.Close()
+
+				if err != nil {
+					values.Close()
+					elem.Close()
+					return err
+				}
+			}
+			values.Close()
+			elem.Close()
+		}
+	case ObjectTypeArray:
+		iter := o.Iterate(true)
+		defer iter.Close()
+		for elem := iter.Next(); elem != nil; elem = iter.Next() {
+			err := l.addValue(depth+1, elem.Position())
+			if err == nil {
+				err = l.countValues(elem, depth+1)
+			}
+			elem.Close()
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// include checks an include at the given depth, where the chunk given
+// to the parser has a depth of 0. Without limits, it returns false,
+// without an error, if the include is past the depth that includes are
+// followed to.
+func (l *parseLimits) include(depth int, pos Position) (bool, error) {
+	if l == nil {
+		return depth <= maxIncludeDepth, nil
+	}
+
+	max := l.opts.MaxIncludeDepth
+	if max == 0 || max > maxIncludeDepth {
+		max = maxIncludeDepth
+	}
+	if depth > max {
+		return false, l.fail(LimitIncludeDepth, int64(max), pos)
+	}
+
+	return true, l.checkContext(pos)
+}
+
+// readFile reads a file without reading more than the parser has left of
+// its MaxBytes. The bytes still have to be counted with addBytes.
+func (l *parseLimits) readFile(path string) ([]byte, error) {
+	if l == nil || l.opts.MaxBytes == 0 {
+		return ioutil.ReadFile(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	remaining := l.opts.MaxBytes - l.bytes
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return ioutil.ReadAll(io.LimitReader(f, remaining+1))
+}
@@ -0,0 +1,230 @@
+package libucl
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParserOptions(t *testing.T) {
+	cases := []struct {
+		Input string
+		Opts  ParserOptions
+		Limit Limit
+	}{
+		{`foo = bar;`, ParserOptions{MaxBytes: 10}, -1},
+		{`foo = "bar";`, ParserOptions{MaxBytes: 10}, LimitBytes},
+		{`a { b { c = 1 } }`, ParserOptions{MaxDepth: 3}, -1},
+		{`a { b { c = 1 } }`, ParserOptions{MaxDepth: 2}, LimitDepth},
+		{`a = [[1]]`, ParserOptions{MaxDepth: 2}, LimitDepth},
+		{`a "b" { c = 1 }`, ParserOptions{MaxDepth: 2}, LimitDepth},
+		{`[{a = 1}]`, ParserOptions{MaxDepth: 2}, -1},
+		{`a = 1; b = [1, 2]`, ParserOptions{MaxObjects: 4}, -1},
+		{`a = 1; b = [1, 2]`, ParserOptions{MaxObjects: 3}, LimitObjects},
+		{`a = 1; a = 2; a = 3`, ParserOptions{MaxObjects: 2}, LimitObjects},
+		{`a "b" { c = 1 }`, ParserOptions{MaxObjects: 2}, LimitObjects},
+	}
+
+	for _, tc := range cases {
+		p := NewParserWithOptions(&tc.Opts)
+		err := p.AddString(tc.Input)
+		p.Close()
+
+		if tc.Limit < 0 {
+			if err != nil {
+				t.Fatalf("input: %s\n\nerr: %s", tc.Input, err)
+			}
+			continue
+		}
+
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("input: %s\n\nbad: %#v", tc.Input, err)
+		}
+		if limitErr.Limit != tc.Limit {
+			t.Fatalf("input: %s\n\nbad: %s", tc.Input, err)
+		}
+	}
+}
+
+func TestParserOptions_nil(t *testing.T) {
+	p := NewParserWithOptions(nil)
+	defer p.Close()
+
+	if err := p.AddString("foo = bar;"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestParserOptions_chunks(t *testing.T) {
+	p := NewParserWithOptions(&ParserOptions{MaxBytes: 20, MaxObjects: 3})
+	defer p.Close()
+
+	if err := p.AddString("foo = bar;"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.AddString("bar = baz; baz = 1;"); err == nil {
+		t.Fatal("should error")
+	}
+
+	// Once a limit is exceeded, the parser can't be used.
+	err := p.AddString("a = b")
+	if limitErr, ok := err.(*LimitError); !ok || limitErr.Limit != LimitBytes {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestParserOptions_include(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.conf": ".include \"$CURDIR/b.conf\"\na = 1;\n",
+		"b.conf": ".include \"$CURDIR/c.conf\"\nb = 2;\n",
+		"c.conf": "c = \"" + strings.Repeat("x", 100) + "\";\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	cases := []struct {
+		Opts  ParserOptions
+		Limit Limit
+	}{
+		{ParserOptions{MaxIncludeDepth: 2}, -1},
+		{ParserOptions{MaxIncludeDepth: 1}, LimitIncludeDepth},
+		{ParserOptions{MaxBytes: 200}, -1},
+		{ParserOptions{MaxBytes: 100}, LimitBytes},
+		{ParserOptions{MaxObjects: 2}, LimitObjects},
+	}
+
+	for i, tc := range cases {
+		p := NewParserWithOptions(&tc.Opts)
+		err := p.AddFile(filepath.Join(dir, "a.conf"))
+		p.Close()
+
+		if tc.Limit < 0 {
+			if err != nil {
+				t.Fatalf("%d: err: %s", i, err)
+			}
+			continue
+		}
+
+		limitErr, ok := err.(*LimitError)
+		if !ok || limitErr.Limit != tc.Limit {
+			t.Fatalf("%d: bad: %#v", i, err)
+		}
+	}
+}
+
+func TestParserOptions_includeVariable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := "c = \"" + strings.Repeat("x", 100) + "\";\n"
+	path := filepath.Join(dir, "c.conf")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := []struct {
+		Input string
+		Opts  ParserOptions
+		Limit Limit
+	}{
+		{`.include "${CONFDIR}/c.conf"`, ParserOptions{MaxBytes: 200}, -1},
+		{`.include "${CONFDIR}/c.conf"`, ParserOptions{MaxBytes: 100}, LimitBytes},
+		{`.load(key="c") "${CONFDIR}/c.conf"`, ParserOptions{MaxBytes: 200}, -1},
+		{`.load(key="c") "${CONFDIR}/c.conf"`, ParserOptions{MaxBytes: 100}, LimitBytes},
+	}
+
+	for _, tc := range cases {
+		p := NewParserWithOptions(&tc.Opts)
+		p.RegisterVariable("CONFDIR", dir)
+		err := p.AddString(tc.Input)
+		p.Close()
+
+		if tc.Limit < 0 {
+			if err != nil {
+				t.Fatalf("input: %s\n\nerr: %s", tc.Input, err)
+			}
+			continue
+		}
+
+		limitErr, ok := err.(*LimitError)
+		if !ok || limitErr.Limit != tc.Limit {
+			t.Fatalf("input: %s\n\nbad: %#v", tc.Input, err)
+		}
+	}
+}
+
+func TestParserOptions_includeLoop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "loop.conf")
+	data := ".include \"$CURDIR/loop.conf\"\na = 1;\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Includes are never followed more than 16 deep.
+	p := NewParserWithOptions(&ParserOptions{MaxIncludeDepth: 100})
+	defer p.Close()
+
+	err = p.AddFile(path)
+	limitErr, ok := err.(*LimitError)
+	if !ok || limitErr.Limit != LimitIncludeDepth || limitErr.Max != 16 {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestParserOptions_context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	p := NewParserWithOptions(&ParserOptions{Context: ctx})
+	defer p.Close()
+
+	err := p.AddString("foo = bar;")
+	if limitErr, ok := err.(*LimitError); !ok || limitErr.Limit != LimitContext {
+		t.Fatalf("bad: %#v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParserOptions_msgpack(t *testing.T) {
+	obj := testParseString(t, `foo = "bar"; bar = [1, 2, 3];`)
+	defer obj.Close()
+
+	data, err := obj.Emit(EmitMsgpack)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p := NewParserWithOptions(&ParserOptions{MaxBytes: int64(len(data) - 1)})
+	defer p.Close()
+
+	err = p.AddMsgpack([]byte(data))
+	if limitErr, ok := err.(*LimitError); !ok || limitErr.Limit != LimitBytes {
+		t.Fatalf("bad: %#v", err)
+	}
+}
@@ -0,0 +1,241 @@
+//go:build purego
+// +build purego
+
+package libucl
+
+import (
+	"fmt"
+	"math"
+)
+
+// AddMsgpack adds MessagePack encoded data to parse, such as the output
+// of Object.Emit with EmitMsgpack.
+func (p *Parser) AddMsgpack(data []byte) error {
+	cancel, err := p.limits.start()
+	defer cancel()
+	if err != nil {
+		return err
+	}
+	if err := p.limits.addBytes(len(data), Position{}); err != nil {
+		return err
+	}
+
+	r := &msgpackReader{data: data}
+	v, err := r.value(0)
+	if err != nil {
+		return err
+	}
+	if r.off != len(data) {
+		return fmt.Errorf("invalid msgpack: trailing data at offset %d", r.off)
+	}
+
+	switch {
+	case p.top == nil:
+		p.top = v
+	case p.top.typ == ObjectTypeObject && v.typ == ObjectTypeObject:
+		for _, k := range v.keys {
+			p.top.insert(k.key, k)
+		}
+	case p.top.typ == ObjectTypeArray && v.typ == ObjectTypeArray:
+		p.top.elems = append(p.top.elems, v.elems...)
+	default:
+		return fmt.Errorf("invalid msgpack: top object is a different type")
+	}
+
+	return nil
+}
+
+// maxMsgpackDepth is how deeply msgpack maps and arrays can be nested.
+const maxMsgpackDepth = 512
+
+// msgpackReader reads msgpack data into objects.
+type msgpackReader struct {
+	data []byte
+	off  int
+}
+
+func (r *msgpackReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid msgpack: %s at offset %d",
+		fmt.Sprintf(format, args...), r.off)
+}
+
+// next returns the next n bytes.
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.off < n {
+		return nil, r.errorf("truncated data")
+	}
+
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b, nil
+}
+
+// uint reads a big endian unsigned integer of n bytes.
+func (r *msgpackReader) uint(n int) (uint64, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+
+	var result uint64
+	for _, c := range b {
+		result = result<<8 | uint64(c)
+	}
+	return result, nil
+}
+
+func (r *msgpackReader) value(depth int) (*object, error) {
+	if depth > maxMsgpackDepth {
+		return nil, r.errorf("nested too deeply")
+	}
+
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c := b[0]; {
+	case c <= 0x7f:
+		return msgpackInt(int64(c)), nil
+	case c >= 0xe0:
+		return msgpackInt(int64(int8(c))), nil
+	case c >= 0x80 && c <= 0x8f:
+		return r.object(int(c&0x0f), depth)
+	case c >= 0x90 && c <= 0x9f:
+		return r.array(int(c&0x0f), depth)
+	case c >= 0xa0 && c <= 0xbf:
+		return r.string(int(c & 0x1f))
+	}
+
+	switch c := b[0]; c {
+	case 0xc0:
+		return newObject(ObjectTypeNull), nil
+	case 0xc2, 0xc3:
+		v := newObject(ObjectTypeBoolean)
+		v.boolean = c == 0xc3
+		return v, nil
+	case 0xc4, 0xd9:
+		return r.sizedString(1)
+	case 0xc5, 0xda:
+		return r.sizedString(2)
+	case 0xc6, 0xdb:
+		return r.sizedString(4)
+	case 0xca:
+		u, err := r.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		v := newObject(ObjectTypeFloat)
+		v.float = float64(math.Float32frombits(uint32(u)))
+		return v, nil
+	case 0xcb:
+		u, err := r.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		v := newObject(ObjectTypeFloat)
+		v.float = math.Float64frombits(u)
+		return v, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := r.uint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return msgpackInt(int64(u)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (c - 0xd0)
+		u, err := r.uint(n)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend from n bytes.
+		shift := uint(64 - 8*n)
+		return msgpackInt(int64(u<<shift) >> shift), nil
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.object(int(n), depth)
+	default:
+		r.off--
+		return nil, r.errorf("unsupported type 0x%02x", c)
+	}
+}
+
+func msgpackInt(i int64) *object {
+	v := newObject(ObjectTypeInt)
+	v.integer = i
+	return v
+}
+
+// sizedString reads a string whose length is stored in n bytes.
+func (r *msgpackReader) sizedString(n int) (*object, error) {
+	size, err := r.uint(n)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(len(r.data)) {
+		return nil, r.errorf("truncated data")
+	}
+
+	return r.string(int(size))
+}
+
+func (r *msgpackReader) string(n int) (*object, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+
+	v := newObject(ObjectTypeString)
+	v.str = string(b)
+	return v, nil
+}
+
+func (r *msgpackReader) array(n int, depth int) (*object, error) {
+	// Every element takes at least a byte, which stops a bad length from
+	// allocating too much.
+	if n > len(r.data)-r.off {
+		return nil, r.errorf("truncated data")
+	}
+
+	v := newObject(ObjectTypeArray)
+	v.elems = make([]*object, 0, n)
+	for i := 0; i < n; i++ {
+		elem, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v.elems = append(v.elems, elem)
+	}
+
+	return v, nil
+}
+
+func (r *msgpackReader) object(n int, depth int) (*object, error) {
+	v := newObject(ObjectTypeObject)
+	for i := 0; i < n; i++ {
+		key, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if key.typ != ObjectTypeString {
+			return nil, r.errorf("key is not a string")
+		}
+
+		elem, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v.insert(key.str, elem)
+	}
+
+	return v, nil
+}
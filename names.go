@@ -0,0 +1,55 @@
+package libucl
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NameMapper maps the name of a struct field to the key it is decoded
+// from. It isn't used for fields that have a key in their tag.
+type NameMapper func(field string) string
+
+// ExactNames is a NameMapper that uses field names as they are. Unlike
+// the default, keys must match them exactly rather than ignoring case.
+func ExactNames(field string) string {
+	return field
+}
+
+// SnakeCase is a NameMapper that maps field names such as "MaxConns" to
+// "max_conns".
+func SnakeCase(field string) string {
+	return strings.Join(splitWords(field), "_")
+}
+
+// KebabCase is a NameMapper that maps field names such as "MaxConns" to
+// "max-conns".
+func KebabCase(field string) string {
+	return strings.Join(splitWords(field), "-")
+}
+
+// splitWords splits a field name into lowercase words at each change of
+// case, keeping acronyms such as "HTTP" in "HTTPServer" together.
+func splitWords(s string) []string {
+	runes := []rune(s)
+
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		if !unicode.IsUpper(runes[i]) {
+			continue
+		}
+
+		prev := runes[i-1]
+		nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+			(unicode.IsUpper(prev) && nextLower) {
+			words = append(words, strings.ToLower(string(runes[start:i])))
+			start = i
+		}
+	}
+	if start < len(runes) {
+		words = append(words, strings.ToLower(string(runes[start:])))
+	}
+
+	return words
+}
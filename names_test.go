@@ -0,0 +1,34 @@
+package libucl
+
+import (
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"Name", "name"},
+		{"MaxConns", "max_conns"},
+		{"HTTPServer", "http_server"},
+		{"UserID", "user_id"},
+		{"ID", "id"},
+		{"Port2", "port2"},
+		{"V2Name", "v2_name"},
+	}
+
+	for _, tc := range cases {
+		actual := SnakeCase(tc.Input)
+		if actual != tc.Output {
+			t.Fatalf("input: %s\n\nbad: %s", tc.Input, actual)
+		}
+	}
+}
+
+func TestKebabCase(t *testing.T) {
+	actual := KebabCase("MaxIdleConns")
+	if actual != "max-idle-conns" {
+		t.Fatalf("bad: %s", actual)
+	}
+}
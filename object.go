@@ -1,20 +1,60 @@
+//go:build !purego
+// +build !purego
+
 package libucl
 
-import "unsafe"
+import (
+	"bytes"
+	"errors"
+	"unsafe"
+)
 
 // #include "go-libucl.h"
 import "C"
 
 // Object represents a single object within a configuration.
+//
+// Objects must not be used by more than one goroutine at a time, even
+// just for reading. Use SafeObject to share an object between goroutines.
 type Object struct {
 	object *C.ucl_object_t
+
+	// comments are the comments saved by the parser, if the parser
+	// was created with ParserSaveComments.
+	comments *C.ucl_object_t
+
+	// source is where the objects created by the parser come from, if
+	// the parser was created with ParserSavePositions.
+	source *objectSource
+}
+
+// objectSource is what a parser knows about where its objects come from
+// in the source.
+type objectSource struct {
+	positions map[*C.ucl_object_t]Position
+
+	// lineComments are the comments at the end of the line each object
+	// ends on. libucl attaches them to another object, and moved is how
+	// many of the comments attached to an object are line comments.
+	lineComments map[*C.ucl_object_t]string
+	moved        map[*C.ucl_object_t]int
+}
+
+func newObjectSource() *objectSource {
+	return &objectSource{
+		positions:    make(map[*C.ucl_object_t]Position),
+		lineComments: make(map[*C.ucl_object_t]string),
+		moved:        make(map[*C.ucl_object_t]int),
+	}
 }
 
 // ObjectIter is an interator for objects.
 type ObjectIter struct {
-	expand bool
-	object *C.ucl_object_t
-	iter   C.ucl_object_iter_t
+	expand   bool
+	object   *C.ucl_object_t
+	comments *C.ucl_object_t
+	source   *objectSource
+	iter     C.ucl_object_iter_t
 }
 
 // ObjectType is an enum of the type that an Object represents.
@@ -41,23 +81,119 @@ const (
 	EmitJSONCompact
 	EmitConfig
 	EmitYAML
+	EmitMsgpack
 )
 
 // Free the memory associated with the object. This must be called when
 // you're done using it.
 func (o *Object) Close() error {
 	C.ucl_object_unref(o.object)
+	if o.comments != nil {
+		C.ucl_object_unref(o.comments)
+	}
 	return nil
 }
 
+// Comments returns the comments that were attached to this object in
+// the source, including the comment markers. Comments are only available
+// if the parser was created with ParserSaveComments.
+func (o *Object) Comments() []string {
+	comments, trailing := o.attachedComments()
+	if trailing {
+		return nil
+	}
+
+	return comments
+}
+
+// LineComment returns the comment at the end of the line that this
+// object ends on, such as "# seconds" in `timeout = 30; # seconds`.
+// libucl attaches such a comment to the value after it instead, so with
+// cgo it is only found if the parser was also created with
+// ParserSavePositions. Otherwise it is returned by Comments of the value
+// after it.
+func (o *Object) LineComment() string {
+	if o.source == nil {
+		return ""
+	}
+
+	return o.source.lineComments[o.object]
+}
+
+// TrailingComments returns the comments after this object that end the
+// object or array it is in, or the source, if this object is the last
+// value there.
+func (o *Object) TrailingComments() []string {
+	comments, trailing := o.attachedComments()
+	if !trailing {
+		return nil
+	}
+
+	return comments
+}
+
+// attachedComments returns the comments attached to this object that
+// aren't line comments of another object, and whether they come after
+// it.
+func (o *Object) attachedComments() ([]string, bool) {
+	if o.comments == nil {
+		return nil, false
+	}
+
+	result, trailing := attachedComments(o.comments, o.object)
+	if o.source != nil {
+		result = result[o.source.moved[o.object]:]
+	}
+	if len(result) == 0 {
+		return nil, false
+	}
+
+	return result, trailing
+}
+
+// attachedComments returns the comments libucl attached to obj, and
+// whether they come after it. libucl marks comments that come after an
+// object as inherited, and writes them after it when emitting.
+func attachedComments(comments, obj *C.ucl_object_t) ([]string, bool) {
+	obj = C.ucl_comments_find(comments, obj)
+	if obj == nil {
+		return nil, false
+	}
+
+	var result []string
+	iter := (&Object{object: obj}).Iterate(true)
+	defer iter.Close()
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		result = append(result, elem.ToString())
+		elem.Close()
+	}
+
+	return result, obj.flags&C.UCL_OBJECT_INHERITED != 0
+}
+
 // Emit converts this object to another format and returns it.
+//
+// If the object has comments (see Comments), they are written back
+// when emitting with EmitConfig.
 func (o *Object) Emit(t Emitter) (string, error) {
-	result := C.ucl_object_emit(o.object, uint32(t))
+	// Comments can only be given to the streaming emitter.
+	if o.comments != nil {
+		var buf bytes.Buffer
+		if err := o.EmitTo(&buf, t); err != nil {
+			return "", err
+		}
+
+		return buf.String(), nil
+	}
+
+	var n C.size_t
+	result := C.ucl_object_emit_len(o.object, uint32(t), &n)
 	if result == nil {
 		return "", nil
 	}
+	defer C.free(unsafe.Pointer(result))
 
-	return C.GoString(C._go_uchar_to_char(result)), nil
+	return C.GoStringN(C._go_uchar_to_char(result), C.int(n)), nil
 }
 
 // Delete removes the given key from the object. The key will automatically
@@ -66,6 +202,10 @@ func (o *Object) Delete(key string) {
 	ckey := C.CString(key)
 	defer C.free(unsafe.Pointer(ckey))
 
+	if o.source != nil {
+		o.source.forget(C.ucl_object_find_keyl(o.object, ckey, C.size_t(len(key))))
+	}
+
 	C.ucl_object_delete_key(o.object, ckey)
 }
 
@@ -78,7 +218,7 @@ func (o *Object) Get(key string) *Object {
 		return nil
 	}
 
-	result := &Object{object: obj}
+	result := &Object{object: obj, comments: o.comments, source: o.source}
 	result.Ref()
 	return result
 }
@@ -91,11 +231,16 @@ func (o *Object) Get(key string) *Object {
 func (o *Object) Iterate(expand bool) *ObjectIter {
 	// Increase the ref count
 	C.ucl_object_ref(o.object)
+	if o.comments != nil {
+		C.ucl_object_ref(o.comments)
+	}
 
 	return &ObjectIter{
-		expand: expand,
-		object: o.object,
-		iter:   nil,
+		expand:   expand,
+		object:   o.object,
+		comments: o.comments,
+		source:   o.source,
+		iter:     nil,
 	}
 }
 
@@ -130,22 +275,166 @@ func (o *Object) Len() uint {
 	return uint(o.object.len)
 }
 
+// isImplicitArray returns true if the object is the first of several
+// values of the same key, which libucl links together into an implicit
+// array.
+func (o *Object) isImplicitArray() bool {
+	return o.object.next != nil
+}
+
+// LookupPath returns the object at the given path, or nil if there is
+// none. The path is made of keys and array indexes separated by dots,
+// such as "servers.0.host".
+func (o *Object) LookupPath(path string) *Object {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	obj := C.ucl_object_lookup_path(o.object, cpath)
+	if obj == nil {
+		return nil
+	}
+
+	result := &Object{object: obj, comments: o.comments, source: o.source}
+	result.Ref()
+	return result
+}
+
+// Position returns where in the source this object was set. For values
+// of keys, this is the position of the key. The position isn't valid if
+// the object wasn't created by a parser with ParserSavePositions from a
+// source it could scan.
+func (o *Object) Position() Position {
+	if o.source == nil {
+		return Position{}
+	}
+
+	return o.source.positions[o.object]
+}
+
+// forget removes what is known about obj, the values of the same key
+// after it, and everything below them. This is done before they are
+// freed, since the address of a freed object can be reused.
+func (src *objectSource) forget(obj *C.ucl_object_t) {
+	for ; obj != nil; obj = obj.next {
+		delete(src.positions, obj)
+		delete(src.lineComments, obj)
+		delete(src.moved, obj)
+
+		switch ObjectType(C.ucl_object_type(obj)) {
+		case ObjectTypeObject, ObjectTypeArray:
+			var iter C.ucl_object_iter_t
+			for {
+				elem := C.ucl_iterate_object(obj, &iter, true)
+				if elem == nil {
+					break
+				}
+				src.forget(elem)
+			}
+		}
+	}
+}
+
+// moveComments moves the line comment of from to to, along with how
+// many of its attached comments were moved, the same as libucl moves the
+// comments themselves.
+func (src *objectSource) moveComments(from, to *C.ucl_object_t) {
+	if c, ok := src.lineComments[from]; ok {
+		src.lineComments[to] = c
+	}
+	if n, ok := src.moved[from]; ok {
+		src.moved[to] = n
+	}
+}
+
+// Priority returns the priority of the chunk this object was parsed from,
+// or the priority set with SetPriority.
+func (o *Object) Priority() uint {
+	return uint(C.ucl_object_get_priority(o.object))
+}
+
 // Increments the ref count associated with this. You have to call
 // close an additional time to free the memory.
 func (o *Object) Ref() error {
 	C.ucl_object_ref(o.object)
+	if o.comments != nil {
+		C.ucl_object_ref(o.comments)
+	}
 	return nil
 }
 
+// Set sets the value of a key in this object, replacing any existing
+// value. The object keeps its own reference to the value, so the value
+// must still be closed by the caller.
+//
+// Comments attached to the replaced value are moved to the new value.
+func (o *Object) Set(key string, value *Object) {
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+
+	old := C.ucl_object_find_keyl(o.object, ckey, C.size_t(len(key)))
+	if old != nil && old != value.object {
+		if o.comments != nil {
+			C.ucl_comments_move(o.comments, old, value.object)
+		}
+		if o.source != nil {
+			o.source.moveComments(old, value.object)
+			o.source.forget(old)
+		}
+	}
+
+	C.ucl_object_ref(value.object)
+	C.ucl_object_replace_key(
+		o.object, value.object, ckey, C.size_t(len(key)), true)
+}
+
+// SetPriority sets the priority of this object. Priorities range from
+// 0 to 15.
+func (o *Object) SetPriority(priority uint) {
+	C.ucl_object_set_priority(o.object, C.uint(priority))
+}
+
 // Returns the type that this object represents.
 func (o *Object) Type() ObjectType {
 	return ObjectType(C.ucl_object_type(o.object))
 }
 
+// Validate validates this object against the given JSON schema.
+func (o *Object) Validate(schema *Object) error {
+	var err C.struct_ucl_schema_error
+	if !C.ucl_object_validate(schema.object, o.object, &err) {
+		return errors.New(C.GoString(&err.msg[0]))
+	}
+
+	return nil
+}
+
 //------------------------------------------------------------------------
 // Conversion Functions
 //------------------------------------------------------------------------
 
+// NewBool returns a new boolean object.
+func NewBool(v bool) *Object {
+	return &Object{object: C.ucl_object_frombool(C._Bool(v))}
+}
+
+// NewFloat returns a new float object.
+func NewFloat(v float64) *Object {
+	return &Object{object: C.ucl_object_fromdouble(C.double(v))}
+}
+
+// NewInt returns a new int object.
+func NewInt(v int64) *Object {
+	return &Object{object: C.ucl_object_fromint(C.int64_t(v))}
+}
+
+// NewString returns a new string object.
+func NewString(v string) *Object {
+	cs := C.CString(v)
+	defer C.free(unsafe.Pointer(cs))
+
+	return &Object{object: C.ucl_object_fromlstring(cs, C.size_t(len(v)))}
+}
+
 func (o *Object) ToBool() bool {
 	return bool(C.ucl_object_toboolean(o.object))
 }
@@ -164,6 +453,9 @@ func (o *Object) ToString() string {
 
 func (o *ObjectIter) Close() {
 	C.ucl_object_unref(o.object)
+	if o.comments != nil {
+		C.ucl_object_unref(o.comments)
+	}
 }
 
 func (o *ObjectIter) Next() *Object {
@@ -174,6 +466,9 @@ func (o *ObjectIter) Next() *Object {
 
 	// Increase the ref count so we have to free it
 	C.ucl_object_ref(obj)
+	if o.comments != nil {
+		C.ucl_object_ref(o.comments)
+	}
 
-	return &Object{object: obj}
+	return &Object{object: obj, comments: o.comments, source: o.source}
 }
@@ -0,0 +1,522 @@
+//go:build purego
+// +build purego
+
+package libucl
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// object is a single value in the pure Go backend. It is laid out like
+// a ucl_object_t: the values of a key that is set more than once are
+// linked with next into an implicit array.
+type object struct {
+	typ      ObjectType
+	key      string
+	priority uint
+	pos      Position
+
+	// comments come before the value, lineComment is at the end of the
+	// line it ends on and trailing come after it at the end of an object
+	// or array.
+	comments    []string
+	lineComment string
+	trailing    []string
+
+	// next is the next value in an implicit array, and last is the last
+	// value of the implicit array that starts with this value.
+	next *object
+	last *object
+
+	// The value, depending on the type. Floats and times are both kept
+	// in float.
+	str     string
+	integer int64
+	float   float64
+	boolean bool
+
+	// multiline is true for strings that were parsed from a heredoc.
+	multiline bool
+
+	// keys are the values of an object in the order they were set, and
+	// index finds them by key. Each value is the start of an implicit
+	// array.
+	keys  []*object
+	index map[string]*object
+
+	// elems are the elements of an array.
+	elems []*object
+}
+
+func newObject(typ ObjectType) *object {
+	o := &object{typ: typ}
+	if typ == ObjectTypeObject {
+		o.index = make(map[string]*object)
+	}
+
+	return o
+}
+
+// insert sets a key of the object o to v. If the key is already set,
+// the value with the highest priority wins, and values with the same
+// priority are added to an implicit array.
+func (o *object) insert(key string, v *object) {
+	v.key = key
+
+	old, ok := o.index[key]
+	if !ok {
+		o.index[key] = v
+		o.keys = append(o.keys, v)
+		return
+	}
+
+	switch {
+	case old.priority == v.priority:
+		if old.last == nil {
+			old.last = old
+		}
+		old.last.next = v
+		old.last = v
+	case old.priority < v.priority:
+		o.replace(old, v)
+	}
+}
+
+// copy returns a deep copy of the value o, along with the rest of its
+// implicit array.
+func (o *object) copy() *object {
+	result := *o
+	result.next, result.last = nil, nil
+	result.comments = nil
+	result.lineComment = ""
+	result.trailing = nil
+
+	switch o.typ {
+	case ObjectTypeObject:
+		result.keys = nil
+		result.index = make(map[string]*object)
+		for _, v := range o.keys {
+			result.insert(v.key, v.copy())
+		}
+	case ObjectTypeArray:
+		result.elems = make([]*object, len(o.elems))
+		for i, v := range o.elems {
+			result.elems[i] = v.copy()
+		}
+	}
+
+	if o.next != nil {
+		next := o.next.copy()
+		result.next = next
+		result.last = next.last
+		if result.last == nil {
+			result.last = next
+		}
+	}
+
+	return &result
+}
+
+// replace replaces the value old of the object o, along with the rest
+// of its implicit array, with v.
+func (o *object) replace(old, v *object) {
+	for i, k := range o.keys {
+		if k == old {
+			o.keys[i] = v
+		}
+	}
+	o.index[v.key] = v
+}
+
+// remove deletes a key from the object o.
+func (o *object) remove(key string) {
+	old, ok := o.index[key]
+	if !ok {
+		return
+	}
+
+	delete(o.index, key)
+	for i, k := range o.keys {
+		if k == old {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Object represents a single object within a configuration.
+//
+// Objects must not be used by more than one goroutine at a time, even
+// just for reading. Use SafeObject to share an object between goroutines.
+type Object struct {
+	object *object
+}
+
+// ObjectIter is an interator for objects.
+type ObjectIter struct {
+	expand bool
+	object *object
+	cur    *object
+	i      int
+}
+
+// ObjectType is an enum of the type that an Object represents.
+type ObjectType int
+
+const (
+	ObjectTypeObject ObjectType = iota
+	ObjectTypeArray
+	ObjectTypeInt
+	ObjectTypeFloat
+	ObjectTypeString
+	ObjectTypeBoolean
+	ObjectTypeTime
+	ObjectTypeUserData
+	ObjectTypeNull
+)
+
+// Emitter is a type of built-in emitter that can be used to convert
+// an object to another config format.
+type Emitter int
+
+const (
+	EmitJSON Emitter = iota
+	EmitJSONCompact
+	EmitConfig
+	EmitYAML
+	EmitMsgpack
+)
+
+// Free the memory associated with the object. This must be called when
+// you're done using it.
+func (o *Object) Close() error {
+	return nil
+}
+
+// Comments returns the comments that were attached to this object in
+// the source, including the comment markers. Comments are only available
+// if the parser was created with ParserSaveComments.
+func (o *Object) Comments() []string {
+	if len(o.object.comments) == 0 {
+		return nil
+	}
+
+	result := make([]string, len(o.object.comments))
+	copy(result, o.object.comments)
+	return result
+}
+
+// LineComment returns the comment at the end of the line that this
+// object ends on, such as "# seconds" in `timeout = 30; # seconds`.
+// libucl attaches such a comment to the value after it instead, so with
+// cgo it is returned by Comments of that value and this is always empty.
+func (o *Object) LineComment() string {
+	return o.object.lineComment
+}
+
+// TrailingComments returns the comments after this object that end the
+// object or array it is in, or the source, if this object is the last
+// value there.
+func (o *Object) TrailingComments() []string {
+	if len(o.object.trailing) == 0 {
+		return nil
+	}
+
+	result := make([]string, len(o.object.trailing))
+	copy(result, o.object.trailing)
+	return result
+}
+
+// Emit converts this object to another format and returns it.
+//
+// If the object has comments (see Comments), they are written back
+// when emitting with EmitConfig.
+func (o *Object) Emit(t Emitter) (string, error) {
+	var buf bytes.Buffer
+	if err := o.EmitTo(&buf, t); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Delete removes the given key from the object. The key will automatically
+// be dereferenced once when this is called.
+func (o *Object) Delete(key string) {
+	if o.object.typ == ObjectTypeObject {
+		o.object.remove(key)
+	}
+}
+
+func (o *Object) Get(key string) *Object {
+	if o.object.typ != ObjectTypeObject {
+		return nil
+	}
+
+	obj, ok := o.object.index[key]
+	if !ok {
+		return nil
+	}
+
+	return &Object{object: obj}
+}
+
+// Iterate over the objects in this object.
+//
+// The iterator must be closed when it is finished.
+//
+// The iterator does not need to be fully consumed.
+func (o *Object) Iterate(expand bool) *ObjectIter {
+	return &ObjectIter{
+		expand: expand,
+		object: o.object,
+	}
+}
+
+// Returns the key of this value/object as a string, or the empty
+// string if the object doesn't have a key.
+func (o *Object) Key() string {
+	return o.object.key
+}
+
+// Len returns the length of the object, or how many elements are part
+// of this object.
+//
+// For objects, this is the number of key/value pairs.
+// For arrays, this is the number of elements.
+func (o *Object) Len() uint {
+	switch o.object.typ {
+	case ObjectTypeObject:
+		// If the object has a "next", then it is actually an array of
+		// objects, and the count is the length of the array.
+		if o.object.next != nil {
+			var count uint
+			for obj := o.object; obj != nil; obj = obj.next {
+				count++
+			}
+
+			return count
+		}
+
+		return uint(len(o.object.keys))
+	case ObjectTypeArray:
+		return uint(len(o.object.elems))
+	case ObjectTypeString:
+		return uint(len(o.object.str))
+	default:
+		return 0
+	}
+}
+
+// isImplicitArray returns true if the object is the first of several
+// values of the same key, which libucl links together into an implicit
+// array.
+func (o *Object) isImplicitArray() bool {
+	return o.object.next != nil
+}
+
+// LookupPath returns the object at the given path, or nil if there is
+// none. The path is made of keys and array indexes separated by dots,
+// such as "servers.0.host".
+func (o *Object) LookupPath(path string) *Object {
+	obj := o.object
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			continue
+		}
+
+		switch obj.typ {
+		case ObjectTypeArray:
+			i, err := strconv.ParseUint(part, 10, 0)
+			if err != nil || i >= uint64(len(obj.elems)) {
+				return nil
+			}
+			obj = obj.elems[i]
+		case ObjectTypeObject:
+			next, ok := obj.index[part]
+			if !ok {
+				return nil
+			}
+			obj = next
+		default:
+			return nil
+		}
+	}
+
+	return &Object{object: obj}
+}
+
+// Position returns where in the source this object was set. For values
+// of keys, this is the position of the key. The position isn't valid if
+// the object wasn't created by a parser with ParserSavePositions.
+func (o *Object) Position() Position {
+	return o.object.pos
+}
+
+// Priority returns the priority of the chunk this object was parsed from,
+// or the priority set with SetPriority.
+func (o *Object) Priority() uint {
+	return o.object.priority
+}
+
+// Increments the ref count associated with this. You have to call
+// close an additional time to free the memory.
+func (o *Object) Ref() error {
+	return nil
+}
+
+// Set sets the value of a key in this object, replacing any existing
+// value. The object keeps its own reference to the value, so the value
+// must still be closed by the caller.
+//
+// Comments attached to the replaced value are moved to the new value.
+func (o *Object) Set(key string, value *Object) {
+	if o.object.typ != ObjectTypeObject {
+		return
+	}
+
+	v := value.object
+	v.key = key
+	if old, ok := o.object.index[key]; ok {
+		if old.comments != nil || old.lineComment != "" || old.trailing != nil {
+			v.comments, old.comments = old.comments, nil
+			v.lineComment, old.lineComment = old.lineComment, ""
+			v.trailing, old.trailing = old.trailing, nil
+		}
+
+		o.object.replace(old, v)
+		return
+	}
+
+	o.object.index[key] = v
+	o.object.keys = append(o.object.keys, v)
+}
+
+// SetPriority sets the priority of this object. Priorities range from
+// 0 to 15.
+func (o *Object) SetPriority(priority uint) {
+	o.object.priority = priority
+}
+
+// Returns the type that this object represents.
+func (o *Object) Type() ObjectType {
+	return o.object.typ
+}
+
+//------------------------------------------------------------------------
+// Conversion Functions
+//------------------------------------------------------------------------
+
+// NewBool returns a new boolean object.
+func NewBool(v bool) *Object {
+	obj := newObject(ObjectTypeBoolean)
+	obj.boolean = v
+	return &Object{object: obj}
+}
+
+// NewFloat returns a new float object.
+func NewFloat(v float64) *Object {
+	obj := newObject(ObjectTypeFloat)
+	obj.float = v
+	return &Object{object: obj}
+}
+
+// NewInt returns a new int object.
+func NewInt(v int64) *Object {
+	obj := newObject(ObjectTypeInt)
+	obj.integer = v
+	return &Object{object: obj}
+}
+
+// NewString returns a new string object.
+func NewString(v string) *Object {
+	obj := newObject(ObjectTypeString)
+	obj.str = v
+	return &Object{object: obj}
+}
+
+func (o *Object) ToBool() bool {
+	return o.object.typ == ObjectTypeBoolean && o.object.boolean
+}
+
+func (o *Object) ToInt() int64 {
+	switch o.object.typ {
+	case ObjectTypeInt:
+		return o.object.integer
+	case ObjectTypeFloat, ObjectTypeTime:
+		return int64(o.object.float)
+	default:
+		return 0
+	}
+}
+
+func (o *Object) ToFloat() float64 {
+	switch o.object.typ {
+	case ObjectTypeInt:
+		return float64(o.object.integer)
+	case ObjectTypeFloat, ObjectTypeTime:
+		return o.object.float
+	default:
+		return 0
+	}
+}
+
+// ToString returns the value of a string. Other scalars are converted
+// to their JSON representation, the same as libucl does.
+func (o *Object) ToString() string {
+	switch o.object.typ {
+	case ObjectTypeString:
+		return o.object.str
+	case ObjectTypeInt:
+		return strconv.FormatInt(o.object.integer, 10)
+	case ObjectTypeFloat, ObjectTypeTime:
+		return formatDouble(o.object.float)
+	case ObjectTypeBoolean:
+		return strconv.FormatBool(o.object.boolean)
+	case ObjectTypeNull:
+		return "null"
+	case ObjectTypeObject:
+		return "object"
+	case ObjectTypeArray:
+		return "array"
+	default:
+		return ""
+	}
+}
+
+func (o *ObjectIter) Close() {}
+
+func (o *ObjectIter) Next() *Object {
+	if o.expand {
+		switch o.object.typ {
+		case ObjectTypeObject:
+			if o.i >= len(o.object.keys) {
+				return nil
+			}
+			o.i++
+			return &Object{object: o.object.keys[o.i-1]}
+		case ObjectTypeArray:
+			if o.i >= len(o.object.elems) {
+				return nil
+			}
+			o.i++
+			return &Object{object: o.object.elems[o.i-1]}
+		}
+	}
+
+	// Treat everything else as an implicit array.
+	switch {
+	case o.i == 0:
+		o.cur = o.object
+	case o.cur != nil:
+		o.cur = o.cur.next
+	}
+	o.i++
+	if o.cur == nil {
+		return nil
+	}
+
+	return &Object{object: o.cur}
+}
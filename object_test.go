@@ -2,6 +2,7 @@ package libucl
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -35,6 +36,98 @@ func TestObjectEmit_EmitConfig(t *testing.T) {
 	}
 }
 
+func TestObjectEmit_EmitMsgpack(t *testing.T) {
+	obj := testParseString(t, "foo = bar;")
+	defer obj.Close()
+
+	result, err := obj.Emit(EmitMsgpack)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// fixmap(1), fixstr "foo", fixstr "bar"
+	expected := "\x81\xa3foo\xa3bar"
+	if result != expected {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectEmit_comments(t *testing.T) {
+	p := NewParser(ParserSaveComments)
+	defer p.Close()
+
+	if err := p.AddString("# the answer\nfoo = 42;\nbar = baz;"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	defer obj.Close()
+
+	value := NewInt(43)
+	defer value.Close()
+	obj.Set("foo", value)
+
+	result, err := obj.Emit(EmitConfig)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.Contains(result, "# the answer") {
+		t.Fatalf("bad: %#v", result)
+	}
+	if !strings.Contains(result, "foo = 43;") {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectComments(t *testing.T) {
+	p := NewParser(ParserSaveComments)
+	defer p.Close()
+
+	if err := p.AddString("# the answer\nfoo = 42;\nbar = baz;"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	defer v.Close()
+
+	expected := []string{"# the answer"}
+	if !reflect.DeepEqual(v.Comments(), expected) {
+		t.Fatalf("bad: %#v", v.Comments())
+	}
+
+	v2 := obj.Get("bar")
+	if v2 == nil {
+		t.Fatal("should find")
+	}
+	defer v2.Close()
+
+	if v2.Comments() != nil {
+		t.Fatalf("bad: %#v", v2.Comments())
+	}
+}
+
+func TestObjectComments_notSaved(t *testing.T) {
+	obj := testParseString(t, "# the answer\nfoo = 42;")
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	defer v.Close()
+
+	if v.Comments() != nil {
+		t.Fatalf("bad: %#v", v.Comments())
+	}
+}
+
 func TestObjectDelete(t *testing.T) {
 	obj := testParseString(t, "bar = baz;")
 	defer obj.Close()
@@ -155,6 +248,105 @@ func TestObjectIterate_array(t *testing.T) {
 	}
 }
 
+func TestObjectLookupPath(t *testing.T) {
+	obj := testParseString(t, "foo { bar = [a, { baz = qux; }]; }")
+	defer obj.Close()
+
+	v := obj.LookupPath("foo.bar.1.baz")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	defer v.Close()
+
+	if v.ToString() != "qux" {
+		t.Fatalf("bad: %#v", v.ToString())
+	}
+
+	if v := obj.LookupPath("foo.nope"); v != nil {
+		v.Close()
+		t.Fatal("should not find")
+	}
+}
+
+func TestObjectPriority(t *testing.T) {
+	obj := testParseString(t, "foo = bar;")
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	defer v.Close()
+	if v == nil {
+		t.Fatal("should find")
+	}
+	if v.Priority() != 0 {
+		t.Fatalf("bad: %#v", v.Priority())
+	}
+
+	v.SetPriority(7)
+	if v.Priority() != 7 {
+		t.Fatalf("bad: %#v", v.Priority())
+	}
+}
+
+func TestObjectSet(t *testing.T) {
+	obj := testParseString(t, "foo = bar;")
+	defer obj.Close()
+
+	value := NewString("baz")
+	defer value.Close()
+	obj.Set("foo", value)
+
+	other := NewBool(true)
+	defer other.Close()
+	obj.Set("bar", other)
+
+	v := obj.Get("foo")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	defer v.Close()
+	if v.ToString() != "baz" {
+		t.Fatalf("bad: %#v", v.ToString())
+	}
+
+	v2 := obj.Get("bar")
+	if v2 == nil {
+		t.Fatal("should find")
+	}
+	defer v2.Close()
+	if !v2.ToBool() {
+		t.Fatalf("bad: %#v", v2.ToBool())
+	}
+}
+
+func TestObjectValidate(t *testing.T) {
+	schema := testParseString(t, `{
+		"type": "object",
+		"properties": {
+			"port": { "type": "integer" }
+		},
+		"required": ["port"]
+	}`)
+	defer schema.Close()
+
+	obj := testParseString(t, "port = 80;")
+	defer obj.Close()
+	if err := obj.Validate(schema); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj2 := testParseString(t, "port = eighty;")
+	defer obj2.Close()
+	if err := obj2.Validate(schema); err == nil {
+		t.Fatal("should error")
+	}
+
+	obj3 := testParseString(t, "host = foo;")
+	defer obj3.Close()
+	if err := obj3.Validate(schema); err == nil {
+		t.Fatal("should error")
+	}
+}
+
 func TestObjectToBool(t *testing.T) {
 	obj := testParseString(t, "foo = true; bar = false;")
 	defer obj.Close()
@@ -1,8 +1,13 @@
+//go:build !purego
+// +build !purego
+
 package libucl
 
 import (
 	"errors"
-	"sync"
+	"runtime/cgo"
+	"strconv"
+	"strings"
 	"unsafe"
 )
 
@@ -17,23 +22,49 @@ type MacroFunc func(string)
 // ParserKeyLowercase will lowercase all keys.
 //
 // ParserKeyZeroCopy will attempt to do a zero-copy parse if possible.
+//
+// ParserSaveComments will save the comments in the source so that they
+// are available with Object.Comments and are written back by Object.Emit.
+//
+// ParserSavePositions will save where in the source each object was set,
+// so that it is available with Object.Position and in decoding errors.
+//
+// ParserDisableMacro will skip macros such as .include rather than run
+// them. Skipped macros are saved as comments if comments are saved.
+//
+// ParserNoFileVars will leave the $CURDIR and $FILENAME variables unset.
 type ParserFlag int
 
 const (
 	ParserKeyLowercase ParserFlag = C.UCL_PARSER_KEY_LOWERCASE
 	ParserZeroCopy                = C.UCL_PARSER_ZEROCOPY
 	ParserNoTime                  = C.UCL_PARSER_NO_TIME
-)
+	ParserSaveComments            = C.UCL_PARSER_SAVE_COMMENTS
+	ParserDisableMacro            = C.UCL_PARSER_DISABLE_MACRO
+	ParserNoFileVars              = C.UCL_PARSER_NO_FILEVARS
 
-// Keeps track of all the macros internally
-var macros map[int]MacroFunc = nil
-var macrosIdx int = 0
-var macrosLock sync.Mutex
+	// ParserSavePositions isn't a libucl flag, so it isn't passed on.
+	ParserSavePositions = 1 << 16
+)
 
 // Parser is responsible for parsing libucl data.
 type Parser struct {
-	macros []int
+	flags  ParserFlag
+	limits *parseLimits
+	macros []cgo.Handle
 	parser *C.struct_ucl_parser
+
+	// variables are the registered variables, which the scanner needs
+	// to find the files libucl includes.
+	variables map[string]string
+
+	// positions are the positions scanned from each chunk, if the parser
+	// was created with ParserSavePositions. source is the positions and
+	// line comments matched up with the parsed objects, which is shared
+	// by every object from Object until another chunk is added.
+	positions *positionIndex
+	source    *objectSource
+	root      Position
 }
 
 // ParseString parses a string and returns the top-level object.
@@ -49,13 +80,25 @@ func ParseString(data string) (*Object, error) {
 
 // NewParser returns a parser
 func NewParser(flags ParserFlag) *Parser {
-	return &Parser{
-		parser: C.ucl_parser_new(C.int(flags)),
+	p := &Parser{
+		flags:  flags,
+		parser: C.ucl_parser_new(C.int(flags &^ ParserSavePositions)),
+	}
+	if flags&ParserSavePositions != 0 {
+		p.positions = newPositionIndex()
 	}
+
+	return p
 }
 
 // AddString adds a string data to parse.
 func (p *Parser) AddString(data string) error {
+	done, err := p.checkLimits([]byte(data), "")
+	defer done()
+	if err != nil {
+		return err
+	}
+
 	cs := C.CString(data)
 	defer C.free(unsafe.Pointer(cs))
 
@@ -64,15 +107,121 @@ func (p *Parser) AddString(data string) error {
 		errstr := C.ucl_parser_get_error(p.parser)
 		return errors.New(C.GoString(errstr))
 	}
-	return nil
+
+	p.scan([]byte(data), "", 0)
+	return p.checkObjects()
+}
+
+// AddStringWithPriority adds a string data to parse with the given
+// priority. Values from a chunk with a higher priority replace the values
+// of the same key from chunks with a lower priority. Priorities range
+// from 0 to 15, and AddString uses a priority of 0.
+func (p *Parser) AddStringWithPriority(data string, priority uint) error {
+	done, err := p.checkLimits([]byte(data), "")
+	defer done()
+	if err != nil {
+		return err
+	}
+
+	cs := C.CString(data)
+	defer C.free(unsafe.Pointer(cs))
+
+	result := C.ucl_parser_add_string_priority(
+		p.parser, cs, C.size_t(len(data)), C.uint(priority))
+	if !result {
+		errstr := C.ucl_parser_get_error(p.parser)
+		return errors.New(C.GoString(errstr))
+	}
+
+	p.scan([]byte(data), "", priority)
+	return p.checkObjects()
 }
 
 // AddFile adds a file to parse.
 func (p *Parser) AddFile(path string) error {
+	return p.AddFileWithPriority(path, 0)
+}
+
+// AddFileWithPriority adds a file to parse with the given priority. See
+// AddStringWithPriority for how priorities are used.
+func (p *Parser) AddFileWithPriority(path string, priority uint) error {
+	if p.positions != nil || p.limits != nil {
+		return p.addFileData(path, priority)
+	}
+
 	cs := C.CString(path)
 	defer C.free(unsafe.Pointer(cs))
 
-	result := C.ucl_parser_add_file(p.parser, cs)
+	result := C.ucl_parser_add_file_priority(
+		p.parser, cs, C.uint(priority))
+	if !result {
+		errstr := C.ucl_parser_get_error(p.parser)
+		return errors.New(C.GoString(errstr))
+	}
+
+	return nil
+}
+
+// addFileData reads a file and gives its data to libucl, the same as
+// ucl_parser_add_file_priority does, so that the data that is checked
+// and scanned is exactly the data that is parsed.
+func (p *Parser) addFileData(path string, priority uint) error {
+	data, err := p.limits.readFile(path)
+	if err != nil {
+		return err
+	}
+
+	done, err := p.checkLimits(data, path)
+	defer done()
+	if err != nil {
+		return err
+	}
+
+	if p.flags&ParserNoFileVars == 0 {
+		cpath := C.CString(path)
+		defer C.free(unsafe.Pointer(cpath))
+		if !C.ucl_parser_set_filevars(p.parser, cpath, true) {
+			errstr := C.ucl_parser_get_error(p.parser)
+			return errors.New(C.GoString(errstr))
+		}
+	}
+
+	cs := C.CBytes(data)
+	defer C.free(cs)
+
+	result := C.ucl_parser_add_chunk_priority(
+		p.parser, (*C.uchar)(cs), C.size_t(len(data)), C.uint(priority))
+	if !result {
+		// libucl only knows the name of the file it is parsing when it
+		// reads the file itself.
+		errstr := C.GoString(C.ucl_parser_get_error(p.parser))
+		return errors.New(strings.Replace(errstr,
+			"error while parsing <unknown>:",
+			"error while parsing "+path+":", 1))
+	}
+
+	p.scan(data, path, priority)
+	return p.checkObjects()
+}
+
+// AddMsgpack adds MessagePack encoded data to parse, such as the output
+// of Object.Emit with EmitMsgpack.
+func (p *Parser) AddMsgpack(data []byte) error {
+	cancel, err := p.limits.start()
+	defer cancel()
+	if err != nil {
+		return err
+	}
+	if err := p.limits.addBytes(len(data), Position{}); err != nil {
+		return err
+	}
+
+	cs := C.CBytes(data)
+	defer C.free(cs)
+
+	result := C.ucl_parser_add_chunk_full(
+		p.parser, (*C.uchar)(cs), C.size_t(len(data)), 0,
+		C.UCL_DUPLICATE_APPEND, C.UCL_PARSE_MSGPACK)
 	if !result {
 		errstr := C.ucl_parser_get_error(p.parser)
 		return errors.New(C.GoString(errstr))
@@ -86,13 +235,80 @@ func (p *Parser) AddFile(path string) error {
 func (p *Parser) Close() {
 	C.ucl_parser_free(p.parser)
 
-	if len(p.macros) > 0 {
-		macrosLock.Lock()
-		defer macrosLock.Unlock()
-		for _, idx := range p.macros {
-			delete(macros, idx)
-		}
+	for _, h := range p.macros {
+		h.Delete()
 	}
+	p.macros = nil
+}
+
+// checkLimits checks a chunk, and the files it includes, against the
+// limits of the parser before it is given to libucl, which can't be
+// stopped once it has started. MaxObjects is left to checkObjects. The returned function must be called once
+// the chunk has been added.
+func (p *Parser) checkLimits(data []byte, filename string) (func(), error) {
+	if p.limits == nil {
+		return func() {}, nil
+	}
+
+	done, err := p.limits.start()
+	if err != nil {
+		return done, err
+	}
+	if err := p.limits.addBytes(len(data), Position{Filename: filename}); err != nil {
+		return done, err
+	}
+
+	s := &positionScanner{
+		src:       data,
+		line:      1,
+		col:       1,
+		filename:  filename,
+		flags:     p.flags,
+		variables: p.variables,
+		limits:    p.limits,
+	}
+	s.scan()
+
+	return done, p.limits.failed()
+}
+
+// checkObjects checks the objects libucl has created against the limits
+// of the parser, once a chunk has been added.
+func (p *Parser) checkObjects() error {
+	if p.limits == nil {
+		return nil
+	}
+	if err := p.limits.checkContext(Position{}); err != nil {
+		return err
+	}
+	if p.limits.opts.MaxDepth == 0 && p.limits.opts.MaxObjects == 0 {
+		return nil
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		return nil
+	}
+	defer obj.Close()
+
+	// The whole tree is counted again, since the values of chunks with
+	// a lower priority may have been replaced.
+	p.limits.objects = 0
+	return p.limits.countValues(obj, 0)
+}
+
+// scan records the positions of the keys in a chunk that was parsed
+// successfully, if the parser saves positions.
+func (p *Parser) scan(data []byte, filename string, priority uint) {
+	if p.positions == nil {
+		return
+	}
+	if !p.root.IsValid() {
+		p.root = Position{Filename: filename, Line: 1, Column: 1}
+	}
+
+	scanPositions(p.positions, data, filename, priority, p.flags, p.variables)
+	p.source = nil
 }
 
 // Retrieves the root-level object for a configuration.
@@ -102,26 +318,42 @@ func (p *Parser) Object() *Object {
 		return nil
 	}
 
-	return &Object{object: obj}
-}
+	result := &Object{object: obj}
 
-// RegisterMacro registers a macro that is called from the configuration.
-func (p *Parser) RegisterMacro(name string, f MacroFunc) {
-	// Register it globally
-	macrosLock.Lock()
-	if macros == nil {
-		macros = make(map[int]MacroFunc)
+	// The comments are owned by the parser, so the object keeps its
+	// own reference to them.
+	if comments := C.ucl_parser_get_comments(p.parser); comments != nil {
+		result.comments = C.ucl_object_ref(comments)
 	}
-	for macros[macrosIdx] != nil {
-		macrosIdx++
+
+	if p.positions != nil {
+		if p.source == nil {
+			p.source = newObjectSource()
+			if p.root.IsValid() {
+				p.source.positions[obj] = p.root
+			}
+
+			refs := make(map[scannedRef]*C.ucl_object_t)
+			p.positions.assign(result, "", make(map[string]int), refs)
+			for ref, obj := range refs {
+				p.source.positions[obj] = p.positions.position(ref)
+			}
+			if result.comments != nil {
+				p.source.moveLineComments(p.positions, refs, result.comments)
+			}
+		}
+		result.source = p.source
 	}
-	idx := macrosIdx
-	macros[idx] = f
-	macrosIdx++
-	macrosLock.Unlock()
 
-	// Register the index with our parser so we can free it
-	p.macros = append(p.macros, idx)
+	return result
+}
+
+// RegisterMacro registers a macro that is called from the configuration.
+func (p *Parser) RegisterMacro(name string, f MacroFunc) {
+	// The handle is passed to libucl as the user data of the macro, and
+	// is kept with our parser so we can free it
+	h := cgo.NewHandle(f)
+	p.macros = append(p.macros, h)
 
 	cname := C.CString(name)
 	defer C.free(unsafe.Pointer(cname))
@@ -130,17 +362,31 @@ func (p *Parser) RegisterMacro(name string, f MacroFunc) {
 		p.parser,
 		cname,
 		C._go_macro_handler_func(),
-		C._go_macro_index(C.int(idx)))
+		C._go_macro_handle(C.uintptr_t(h)))
+}
+
+// RegisterVariable registers a variable that is expanded in strings as
+// $name or ${name}.
+func (p *Parser) RegisterVariable(name, value string) {
+	if p.variables == nil {
+		p.variables = make(map[string]string)
+	}
+	p.variables[name] = value
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+
+	C.ucl_parser_register_variable(p.parser, cname, cvalue)
 }
 
 //export go_macro_call
-func go_macro_call(id C.int, data *C.char, n C.int) C.bool {
-	macrosLock.Lock()
-	f := macros[int(id)]
-	macrosLock.Unlock()
+func go_macro_call(h C.uintptr_t, data *C.char, n C.int) C.bool {
+	f, ok := cgo.Handle(h).Value().(MacroFunc)
 
 	// Macro not found, return error
-	if f == nil {
+	if !ok || f == nil {
 		return false
 	}
 
@@ -148,3 +394,84 @@ func go_macro_call(id C.int, data *C.char, n C.int) C.bool {
 	f(C.GoStringN(data, n))
 	return true
 }
+
+// assign walks the object o at the given path and records which scanned
+// position belongs to every object below it in result.
+func (idx *positionIndex) assign(
+	o *Object, path string,
+	cursors map[string]int, result map[scannedRef]*C.ucl_object_t) {
+	switch o.Type() {
+	case ObjectTypeObject:
+		iter := o.Iterate(true)
+		defer iter.Close()
+		for elem := iter.Next(); elem != nil; elem = iter.Next() {
+			elemPath := joinPath(path, elem.Key())
+
+			values := elem.Iterate(false)
+			for v := values.Next(); v != nil; v = values.Next() {
+				if ref, ok := idx.next(elemPath, v.Type(), int(v.Priority()), cursors); ok {
+					result[ref] = v.object
+				}
+
+				idx.assign(v, elemPath, cursors, result)
+				v.Close()
+			}
+			values.Close()
+			elem.Close()
+		}
+	case ObjectTypeArray:
+		i := 0
+		iter := o.Iterate(true)
+		defer iter.Close()
+		for elem := iter.Next(); elem != nil; elem = iter.Next() {
+			elemPath := joinPath(path, strconv.Itoa(i))
+			if ref, ok := idx.next(elemPath, elem.Type(), -1, cursors); ok {
+				result[ref] = elem.object
+			}
+
+			idx.assign(elem, elemPath, cursors, result)
+			elem.Close()
+			i++
+		}
+	}
+}
+
+// moveLineComments moves the line comments found by the scanner back to
+// the values they follow, from the values libucl attached them to.
+// Comments are only moved if libucl attached them where expected.
+func (src *objectSource) moveLineComments(
+	idx *positionIndex, refs map[scannedRef]*C.ucl_object_t,
+	comments *C.ucl_object_t) {
+	for _, lc := range idx.lineComments {
+		owner, ok := refs[lc.owner]
+		if !ok {
+			continue
+		}
+		holder := owner
+		if lc.next != nil {
+			if holder, ok = refs[*lc.next]; !ok {
+				continue
+			}
+		}
+
+		attached, _ := attachedComments(comments, holder)
+		moved := src.moved[holder]
+		if len(attached) < moved+len(lc.comments) {
+			continue
+		}
+
+		matched := true
+		for i, c := range lc.comments {
+			if attached[moved+i] != c {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		src.moved[holder] = moved + len(lc.comments)
+		src.lineComments[owner] = strings.Join(lc.comments, " ")
+	}
+}
@@ -0,0 +1,1327 @@
+//go:build purego
+// +build purego
+
+package libucl
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MacroFunc is the callback type for macros.
+type MacroFunc func(string)
+
+// ParserFlag are flags that can be used to initialize a parser.
+//
+// ParserKeyLowercase will lowercase all keys.
+//
+// ParserKeyZeroCopy will attempt to do a zero-copy parse if possible.
+//
+// ParserSaveComments will save the comments in the source so that they
+// are available with Object.Comments and are written back by Object.Emit.
+//
+// ParserSavePositions will save where in the source each object was set,
+// so that it is available with Object.Position and in decoding errors.
+//
+// ParserDisableMacro will skip macros such as .include rather than run
+// them. Skipped macros are saved as comments if comments are saved.
+//
+// ParserNoFileVars will leave the $CURDIR and $FILENAME variables unset.
+type ParserFlag int
+
+// The values match the libucl flags.
+const (
+	ParserKeyLowercase ParserFlag = 1 << 0
+	ParserZeroCopy                = 1 << 1
+	ParserNoTime                  = 1 << 2
+	ParserSaveComments            = 1 << 4
+	ParserDisableMacro            = 1 << 5
+	ParserNoFileVars              = 1 << 6
+
+	// ParserSavePositions isn't a libucl flag.
+	ParserSavePositions = 1 << 16
+)
+
+// Parser is responsible for parsing libucl data.
+type Parser struct {
+	flags     ParserFlag
+	limits    *parseLimits
+	macros    map[string]MacroFunc
+	variables map[string]string
+	top       *object
+}
+
+// ParseString parses a string and returns the top-level object.
+func ParseString(data string) (*Object, error) {
+	p := NewParser(0)
+	defer p.Close()
+	if err := p.AddString(data); err != nil {
+		return nil, err
+	}
+
+	return p.Object(), nil
+}
+
+// NewParser returns a parser
+func NewParser(flags ParserFlag) *Parser {
+	p := &Parser{
+		flags:     flags,
+		macros:    make(map[string]MacroFunc),
+		variables: make(map[string]string),
+	}
+	p.setFileVariables("")
+
+	return p
+}
+
+// AddString adds a string data to parse.
+func (p *Parser) AddString(data string) error {
+	return p.AddStringWithPriority(data, 0)
+}
+
+// AddStringWithPriority adds a string data to parse with the given
+// priority. Values from a chunk with a higher priority replace the values
+// of the same key from chunks with a lower priority. Priorities range
+// from 0 to 15, and AddString uses a priority of 0.
+func (p *Parser) AddStringWithPriority(data string, priority uint) error {
+	return p.parseChunk([]byte(data), "", priority)
+}
+
+// AddFile adds a file to parse.
+func (p *Parser) AddFile(path string) error {
+	return p.AddFileWithPriority(path, 0)
+}
+
+// AddFileWithPriority adds a file to parse with the given priority. See
+// AddStringWithPriority for how priorities are used.
+func (p *Parser) AddFileWithPriority(path string, priority uint) error {
+	data, err := p.limits.readFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot open file %s: %s", path, err)
+	}
+
+	p.setFileVariables(path)
+	return p.parseChunk(data, path, priority)
+}
+
+// Closes the parser. Once it is closed it can no longer be used. You
+// should always close the parser once you're done with it to clean up
+// any unused memory.
+func (p *Parser) Close() {
+	p.macros = nil
+}
+
+// Retrieves the root-level object for a configuration.
+func (p *Parser) Object() *Object {
+	if p.top == nil {
+		return nil
+	}
+
+	return &Object{object: p.top}
+}
+
+// RegisterMacro registers a macro that is called from the configuration.
+func (p *Parser) RegisterMacro(name string, f MacroFunc) {
+	p.macros[name] = f
+}
+
+// RegisterVariable registers a variable that is expanded in strings as
+// $name or ${name}.
+func (p *Parser) RegisterVariable(name, value string) {
+	p.variables[name] = value
+}
+
+// setFileVariables sets the CURDIR and FILENAME variables for the file
+// at path, or for the working directory if path is empty, the same way
+// libucl does.
+func (p *Parser) setFileVariables(path string) {
+	if p.flags&ParserNoFileVars != 0 {
+		return
+	}
+
+	p.variables["FILENAME"], p.variables["CURDIR"] = fileVariables(path)
+}
+
+// parseChunk parses a top-level chunk into the top object.
+func (p *Parser) parseChunk(src []byte, filename string, priority uint) error {
+	cancel, err := p.limits.start()
+	defer cancel()
+	if err != nil {
+		return err
+	}
+	if err := p.limits.addBytes(len(src), Position{Filename: filename}); err != nil {
+		return err
+	}
+
+	c := p.newChunkParser(src, filename, priority, 0)
+
+	c.skipSpace(true)
+	if p.top == nil {
+		typ := ObjectTypeObject
+		if c.peek() == '[' {
+			typ = ObjectTypeArray
+		}
+
+		top := newObject(typ)
+		top.priority = priority
+		top.pos = c.position(Position{Filename: filename, Line: 1, Column: 1})
+		c.top = top
+		if err := c.parseInto(top); err != nil {
+			return err
+		}
+
+		p.top = top
+		return nil
+	}
+
+	c.top = p.top
+	return c.parseInto(p.top)
+}
+
+func (p *Parser) newChunkParser(
+	src []byte, filename string, priority uint, depth int) *chunkParser {
+	return &chunkParser{
+		p:        p,
+		src:      src,
+		line:     1,
+		col:      1,
+		filename: filename,
+		priority: priority,
+		depth:    depth,
+		level:    1,
+	}
+}
+
+// chunkParser parses a single chunk of UCL.
+type chunkParser struct {
+	p        *Parser
+	src      []byte
+	off      int
+	line     int
+	col      int
+	filename string
+	priority uint
+	depth    int
+
+	// top is the top-level object, which objects are inherited from.
+	top *object
+
+	// level is the depth of the values in the object or array being
+	// parsed, as counted by ParserOptions.MaxDepth.
+	level int
+
+	// comments are the comments that will be attached to the next value
+	// if the parser saves comments.
+	comments []string
+
+	// last is the last value parsed in the current object or array, and
+	// lastLine is the line it ended on. A comment that starts on that
+	// line is its line comment.
+	last     *object
+	lastLine int
+
+	// err is set if a comment isn't finished. The comment takes up the
+	// rest of the chunk, so it is reported instead of whatever error that
+	// causes.
+	err error
+}
+
+func (c *chunkParser) eof() bool {
+	return c.off >= len(c.src)
+}
+
+func (c *chunkParser) peek() byte {
+	if c.eof() {
+		return 0
+	}
+
+	return c.src[c.off]
+}
+
+func (c *chunkParser) peekAt(n int) byte {
+	if c.off+n >= len(c.src) {
+		return 0
+	}
+
+	return c.src[c.off+n]
+}
+
+func (c *chunkParser) advance() {
+	if c.eof() {
+		return
+	}
+
+	if c.src[c.off] == '\n' {
+		c.line++
+		c.col = 1
+	} else {
+		c.col++
+	}
+	c.off++
+}
+
+func (c *chunkParser) pos() Position {
+	return Position{Filename: c.filename, Line: c.line, Column: c.col}
+}
+
+// position returns the position to save for an object, which is only
+// known if the parser saves positions.
+func (c *chunkParser) position(pos Position) Position {
+	if c.p.flags&ParserSavePositions == 0 {
+		return Position{}
+	}
+
+	return pos
+}
+
+// errorf returns a parse error at the current offset, formatted the same
+// way as the errors from libucl.
+func (c *chunkParser) errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+
+	filename := c.filename
+	if filename == "" {
+		filename = "<unknown>"
+	}
+
+	if c.eof() {
+		return fmt.Errorf(
+			"error while parsing %s: at the end of chunk: %s", filename, msg)
+	}
+
+	ch := c.peek()
+	if ch > ' ' && ch < 0x7f {
+		return fmt.Errorf(
+			"error while parsing %s: line: %d, column: %d - '%s', character: '%c'",
+			filename, c.line, c.col, msg, ch)
+	}
+
+	return fmt.Errorf(
+		"error while parsing %s: line: %d, column: %d - '%s', character: '0x%02x'",
+		filename, c.line, c.col, msg, ch)
+}
+
+// parseInto parses the whole chunk into the container, which is the top
+// object or the object a file is included into.
+func (c *chunkParser) parseInto(container *object) error {
+	err := c.parseContainer(container)
+	if c.err != nil {
+		return c.err
+	}
+
+	return err
+}
+
+func (c *chunkParser) parseContainer(container *object) error {
+	c.skipSpace(true)
+
+	switch {
+	case container.typ == ObjectTypeArray:
+		if c.peek() != '[' {
+			return c.errorf("top object is an array, chunk is not")
+		}
+		if err := c.parseArray(container); err != nil {
+			return err
+		}
+	case c.peek() == '[':
+		return c.errorf("top object is an object, chunk is an array")
+	case c.peek() == '{':
+		if err := c.parseObject(container, '}'); err != nil {
+			return err
+		}
+	default:
+		return c.parseObject(container, 0)
+	}
+
+	c.skipSeparators()
+	if !c.eof() {
+		return c.errorf("trailing characters after the top object")
+	}
+
+	return nil
+}
+
+// skipSpace skips whitespace and comments. If newlines is false, it stops
+// at the end of the line.
+func (c *chunkParser) skipSpace(newlines bool) {
+	for !c.eof() {
+		ch := c.peek()
+		switch {
+		case ch == '\n' && !newlines:
+			return
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			c.advance()
+		case ch == '#':
+			start, line := c.off, c.line
+			for !c.eof() && c.peek() != '\n' {
+				c.advance()
+			}
+			c.saveComment(start, line)
+		case ch == '/' && c.peekAt(1) == '*':
+			// Multi-line comments can be nested.
+			open := *c
+			start, line := c.off, c.line
+			nesting := 0
+			for !c.eof() {
+				if c.peek() == '/' && c.peekAt(1) == '*' {
+					nesting++
+					c.advance()
+				} else if c.peek() == '*' && c.peekAt(1) == '/' {
+					nesting--
+					c.advance()
+					if nesting == 0 {
+						c.advance()
+						break
+					}
+				}
+				c.advance()
+			}
+			if nesting > 0 && c.err == nil {
+				c.err = open.errorf("unfinished multiline comment")
+			}
+			c.saveComment(start, line)
+		default:
+			return
+		}
+	}
+}
+
+// saveComment saves the comment from start to the current offset, which
+// started on line.
+func (c *chunkParser) saveComment(start, line int) {
+	if c.p.flags&ParserSaveComments == 0 {
+		return
+	}
+
+	comment := strings.TrimRight(string(c.src[start:c.off]), "\r")
+	if c.last != nil && line == c.lastLine {
+		if c.last.lineComment != "" {
+			comment = c.last.lineComment + " " + comment
+		}
+		c.last.lineComment = comment
+		return
+	}
+
+	c.comments = append(c.comments, comment)
+}
+
+// takeComments returns the comments since the last value, to be attached
+// to the next one.
+func (c *chunkParser) takeComments() []string {
+	comments := c.comments
+	c.comments = nil
+	return comments
+}
+
+// attachComments attaches the comments since the last value to v.
+func (c *chunkParser) attachComments(v *object) {
+	if len(c.comments) > 0 {
+		v.comments = append(v.comments, c.comments...)
+		c.comments = nil
+	}
+}
+
+// endContainer is called at the end of an object or array whose last
+// value is last, to attach the comments after it.
+func (c *chunkParser) endContainer(last *object) {
+	if last != nil && len(c.comments) > 0 {
+		last.trailing = append(last.trailing, c.comments...)
+		c.comments = nil
+	}
+
+	c.last = nil
+}
+
+// ended records that v was the last value parsed, ending on the current
+// line.
+func (c *chunkParser) ended(v *object) {
+	c.last = v
+	c.lastLine = c.line
+}
+
+// skipSeparators skips whitespace, comments and value separators.
+func (c *chunkParser) skipSeparators() {
+	for {
+		c.skipSpace(true)
+		if ch := c.peek(); ch != ',' && ch != ';' {
+			return
+		}
+		c.advance()
+	}
+}
+
+// parseObject parses the keys of an object. If closing isn't 0, the
+// object starts with an opening bracket at the current offset and ends
+// with closing, otherwise it ends at the end of the chunk.
+func (c *chunkParser) parseObject(o *object, closing byte) error {
+	// Errors for unclosed objects are reported at the opening bracket.
+	open := *c
+	if closing != 0 {
+		c.advance()
+	}
+	c.last = nil
+
+	var last *object
+	for {
+		c.skipSeparators()
+		if c.eof() {
+			if closing != 0 {
+				return open.errorf("unfinished object")
+			}
+			break
+		}
+
+		ch := c.peek()
+		if ch == closing {
+			c.advance()
+			break
+		}
+		if ch == '}' || ch == ']' {
+			return c.errorf("unexpected closing bracket")
+		}
+		if ch == '.' && isKeyStart(c.peekAt(1)) {
+			if err := c.parseMacro(o); err != nil {
+				return err
+			}
+			continue
+		}
+
+		v, err := c.parseKeyValue(o)
+		if err != nil {
+			return err
+		}
+		c.ended(v)
+		last = v
+	}
+
+	c.endContainer(last)
+	return nil
+}
+
+// parseKeyValue parses a key and its value, and sets it in o. It returns
+// the value.
+func (c *chunkParser) parseKeyValue(o *object) (*object, error) {
+	// The comments before the key are taken now, so that they aren't
+	// attached to the first value of an object.
+	comments := c.takeComments()
+
+	pos := c.pos()
+	key, err := c.parseKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// A key can be followed by more keys on the same line to create
+	// nested objects, such as `bundle "foo" { ... }`.
+	type section struct {
+		key string
+		pos Position
+	}
+	var sections []section
+	for {
+		c.skipSpace(false)
+		ch := c.peek()
+		if ch == '=' || ch == ':' {
+			c.advance()
+			c.skipSpace(true)
+			break
+		}
+		if ch == '{' || ch == '[' || (sections == nil && !c.sectionFollows()) {
+			break
+		}
+
+		err := c.p.limits.addValue(c.level+len(sections), pos)
+		if err != nil {
+			return nil, err
+		}
+
+		sections = append(sections, section{key: key, pos: pos})
+		pos = c.pos()
+		if key, err = c.parseKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.eof() {
+		return nil, c.errorf("missing value for key %s", key)
+	}
+
+	c.level += len(sections)
+	v, err := c.parseValue(pos)
+	c.level -= len(sections)
+	if err != nil {
+		return nil, err
+	}
+	c.attachComments(v)
+	v.comments = append(comments, v.comments...)
+
+	// Each section is a new object, so a section that is repeated becomes
+	// an implicit array, the same as any other key.
+	result := v
+	for i := len(sections) - 1; i >= 0; i-- {
+		outer := newObject(ObjectTypeObject)
+		outer.priority = c.priority
+		outer.pos = c.position(sections[i].pos)
+		outer.insert(c.key(key), v)
+
+		key = sections[i].key
+		v = outer
+	}
+
+	o.insert(c.key(key), v)
+	return result, nil
+}
+
+// sectionFollows returns true if the next tokens on the line are the
+// keys of a nested section, which means they end with a "{".
+func (c *chunkParser) sectionFollows() bool {
+	saved := *c
+	defer func() { *c = saved }()
+
+	for {
+		if _, err := c.parseKey(); err != nil {
+			return false
+		}
+
+		c.skipSpace(false)
+		if c.peek() == '{' {
+			return true
+		}
+	}
+}
+
+func (c *chunkParser) key(k string) string {
+	if c.p.flags&ParserKeyLowercase != 0 {
+		return strings.ToLower(k)
+	}
+
+	return k
+}
+
+// parseKey parses a key, which is either quoted or bare.
+func (c *chunkParser) parseKey() (string, error) {
+	switch ch := c.peek(); {
+	case ch == '"' || ch == '\'':
+		return c.parseQuoted()
+	case isAtomChar(ch):
+		start := c.off
+		for !c.eof() && isAtomChar(c.peek()) {
+			c.advance()
+		}
+		return string(c.src[start:c.off]), nil
+	default:
+		return "", c.errorf("invalid character in a key")
+	}
+}
+
+// parseArray parses an array, starting at its opening bracket.
+func (c *chunkParser) parseArray(a *object) error {
+	open := *c
+	c.advance()
+	c.last = nil
+
+	var last *object
+	for {
+		c.skipSeparators()
+		if c.eof() {
+			return open.errorf("unfinished array")
+		}
+
+		switch c.peek() {
+		case ']':
+			c.advance()
+			c.endContainer(last)
+			return nil
+		case '}':
+			return c.errorf("unexpected closing bracket")
+		}
+
+		comments := c.takeComments()
+		v, err := c.parseValue(c.pos())
+		if err != nil {
+			return err
+		}
+		c.attachComments(v)
+		v.comments = append(comments, v.comments...)
+		c.ended(v)
+		last = v
+
+		a.elems = append(a.elems, v)
+	}
+}
+
+// parseValue parses a value whose key or element starts at pos.
+func (c *chunkParser) parseValue(pos Position) (*object, error) {
+	if err := c.p.limits.addValue(c.level, pos); err != nil {
+		return nil, err
+	}
+
+	var v *object
+	switch ch := c.peek(); {
+	case ch == '{':
+		v = newObject(ObjectTypeObject)
+		c.level++
+		err := c.parseObject(v, '}')
+		c.level--
+		if err != nil {
+			return nil, err
+		}
+	case ch == '[':
+		v = newObject(ObjectTypeArray)
+		c.level++
+		err := c.parseArray(v)
+		c.level--
+		if err != nil {
+			return nil, err
+		}
+	case ch == '"' || ch == '\'':
+		s, err := c.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		if ch == '"' {
+			s = c.expand(s)
+		}
+
+		v = newObject(ObjectTypeString)
+		v.str = s
+		if err := c.endValue(); err != nil {
+			return nil, err
+		}
+	case c.heredocFollows():
+		v = newObject(ObjectTypeString)
+		v.str = c.parseHeredoc()
+		v.multiline = true
+		if err := c.endValue(); err != nil {
+			return nil, err
+		}
+	default:
+		start := *c
+		atom := c.parseAtom()
+		if atom == "" {
+			return nil, c.errorf("value is expected")
+		}
+
+		var err error
+		if v, err = c.atomValue(atom); err != nil {
+			return nil, start.errorf("%s", err)
+		}
+	}
+
+	v.priority = c.priority
+	v.pos = c.position(pos)
+	return v, nil
+}
+
+// endValue checks that a value is followed by a separator, a comment,
+// the end of an object or array, or the end of the line.
+func (c *chunkParser) endValue() error {
+	for {
+		switch ch := c.peek(); {
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			c.advance()
+		case ch == '#' || (ch == '/' && c.peekAt(1) == '*'):
+			// A comment is a separator, the same as in libucl.
+			return nil
+		case ch == 0 || ch == '\n' || ch == ';' || ch == ',' || ch == '}' || ch == ']':
+			return nil
+		default:
+			return c.errorf("delimiter is missing")
+		}
+	}
+}
+
+// parseAtom parses a bare value up to the end of the value, without
+// trailing whitespace. Brackets in the value are balanced the same as in
+// libucl, so a closing bracket only ends the value if it doesn't close a
+// bracket in the value itself, such as in ${NAME}.
+func (c *chunkParser) parseAtom() string {
+	start := c.off
+	end := c.off
+	braces, brackets := 0, 0
+	for !c.eof() {
+		ch := c.peek()
+		if ch == '}' {
+			if braces == 0 {
+				break
+			}
+			braces--
+		} else if ch == ']' {
+			if brackets == 0 {
+				break
+			}
+			brackets--
+		} else if ch == ';' || ch == ',' || ch == '\n' || ch == '#' ||
+			(ch == '/' && c.peekAt(1) == '*') {
+			break
+		} else if ch == '{' {
+			braces++
+		} else if ch == '[' {
+			brackets++
+		}
+
+		c.advance()
+		if ch != ' ' && ch != '\t' && ch != '\r' {
+			end = c.off
+		}
+	}
+
+	return string(c.src[start:end])
+}
+
+// atomValue returns the value of a bare atom, which is a number,
+// boolean, null or string.
+func (c *chunkParser) atomValue(atom string) (*object, error) {
+	switch strings.ToLower(atom) {
+	case "true", "yes", "on":
+		v := newObject(ObjectTypeBoolean)
+		v.boolean = true
+		return v, nil
+	case "false", "no", "off":
+		return newObject(ObjectTypeBoolean), nil
+	case "null":
+		return newObject(ObjectTypeNull), nil
+	}
+
+	if v, ok, err := parseNumberAtom(atom, c.p.flags&ParserNoTime != 0); ok {
+		return v, err
+	}
+
+	v := newObject(ObjectTypeString)
+	v.str = c.expand(atom)
+	return v, nil
+}
+
+// parseQuoted parses a single or double quoted string.
+func (c *chunkParser) parseQuoted() (string, error) {
+	quote := c.peek()
+	c.advance()
+
+	var buf []byte
+	for {
+		if c.eof() {
+			return "", c.errorf("unfinished string")
+		}
+
+		ch := c.peek()
+		if ch == quote {
+			c.advance()
+			return string(buf), nil
+		}
+		if ch == '\n' && quote == '"' {
+			return "", c.errorf("unexpected newline in a string")
+		}
+
+		c.advance()
+		if ch != '\\' {
+			buf = append(buf, ch)
+			continue
+		}
+
+		if c.eof() {
+			return "", c.errorf("unfinished string")
+		}
+		esc := c.peek()
+		c.advance()
+
+		if quote == '\'' {
+			switch esc {
+			case '\'', '\\':
+				buf = append(buf, esc)
+			case '\n':
+				// A line continuation.
+			default:
+				buf = append(buf, '\\', esc)
+			}
+			continue
+		}
+
+		switch esc {
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'u':
+			if c.off+4 > len(c.src) {
+				return "", c.errorf("invalid unicode escape")
+			}
+			r, err := strconv.ParseUint(string(c.src[c.off:c.off+4]), 16, 32)
+			if err != nil {
+				return "", c.errorf("invalid unicode escape")
+			}
+			for i := 0; i < 4; i++ {
+				c.advance()
+			}
+			buf = append(buf, string(rune(r))...)
+		default:
+			buf = append(buf, esc)
+		}
+	}
+}
+
+// heredocFollows returns true if a heredoc such as <<EOD starts at the
+// current offset.
+func (c *chunkParser) heredocFollows() bool {
+	if c.peek() != '<' || c.peekAt(1) != '<' {
+		return false
+	}
+
+	i := 2
+	for ch := c.peekAt(i); ch >= 'A' && ch <= 'Z'; ch = c.peekAt(i) {
+		i++
+	}
+
+	return i > 2 && (c.peekAt(i) == '\n' ||
+		(c.peekAt(i) == '\r' && c.peekAt(i+1) == '\n'))
+}
+
+func (c *chunkParser) parseHeredoc() string {
+	c.advance()
+	c.advance()
+
+	start := c.off
+	for c.peek() >= 'A' && c.peek() <= 'Z' {
+		c.advance()
+	}
+	term := string(c.src[start:c.off])
+	for c.peek() != '\n' {
+		c.advance()
+	}
+	c.advance()
+
+	// The heredoc ends with the terminator at the start of a line,
+	// followed by the end of the value.
+	bodyStart := c.off
+	for !c.eof() {
+		lineStart := c.off
+		if heredocEnds(c.src[lineStart:], term) {
+			for i := 0; i < len(term); i++ {
+				c.advance()
+			}
+			body := string(c.src[bodyStart:lineStart])
+			return strings.TrimSuffix(strings.TrimSuffix(body, "\n"), "\r")
+		}
+
+		for !c.eof() && c.peek() != '\n' {
+			c.advance()
+		}
+		c.advance()
+	}
+
+	return string(c.src[bodyStart:])
+}
+
+// heredocEnds returns true if line starts with the terminator of a
+// heredoc.
+func heredocEnds(line []byte, term string) bool {
+	if !bytes.HasPrefix(line, []byte(term)) {
+		return false
+	}
+
+	rest := line[len(term):]
+	if len(rest) == 0 {
+		return true
+	}
+
+	switch rest[0] {
+	case '\r', '\n', ';', ',', ' ', '\t', '}', ']':
+		return true
+	default:
+		return false
+	}
+}
+
+// expand expands the variables in s. Unknown variables are left as they
+// are.
+func (c *chunkParser) expand(s string) string {
+	s, _ = expandVariables(s, c.p.variables)
+	return s
+}
+
+// parseMacro parses a macro and its argument, and runs it. Includes are
+// parsed into the object o.
+func (c *chunkParser) parseMacro(o *object) error {
+	macroStart, macroLine := c.off, c.line
+	c.advance()
+	start := c.off
+	for !c.eof() && isKeyChar(c.peek()) {
+		c.advance()
+	}
+	name := string(c.src[start:c.off])
+
+	params := make(map[string]string)
+	c.skipSpace(false)
+	if c.peek() == '(' {
+		c.advance()
+		start := c.off
+		for !c.eof() && c.peek() != ')' {
+			c.advance()
+		}
+		if c.eof() {
+			return c.errorf("unfinished macro parameters")
+		}
+		for _, param := range strings.Split(string(c.src[start:c.off]), ",") {
+			parts := strings.SplitN(param, "=", 2)
+			if len(parts) == 2 {
+				k := strings.TrimSpace(parts[0])
+				params[k] = strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+			}
+		}
+		c.advance()
+	}
+
+	c.skipSpace(false)
+	var arg string
+	switch ch := c.peek(); {
+	case ch == '{':
+		// The argument is a block, which isn't parsed as config.
+		c.advance()
+		start := c.off
+		nesting := 1
+		for !c.eof() {
+			switch c.peek() {
+			case '{':
+				nesting++
+			case '}':
+				nesting--
+			}
+			if nesting == 0 {
+				break
+			}
+			c.advance()
+		}
+		if c.eof() {
+			return c.errorf("unfinished macro block")
+		}
+		arg = string(c.src[start:c.off])
+		c.advance()
+	case ch == '"' || ch == '\'':
+		s, err := c.parseQuoted()
+		if err != nil {
+			return err
+		}
+		arg = c.expand(s)
+	case c.heredocFollows():
+		arg = c.parseHeredoc()
+	default:
+		arg = c.expand(c.parseAtom())
+	}
+
+	// A disabled macro is skipped the same as a comment.
+	if c.p.flags&ParserDisableMacro != 0 {
+		c.saveComment(macroStart, macroLine)
+		return nil
+	}
+
+	switch name {
+	case "include", "try_include":
+		return c.include(o, arg, name == "try_include", params)
+	case "priority":
+		return c.setPriority(arg, params)
+	case "inherit":
+		return c.inherit(o, arg)
+	case "load":
+		return c.load(o, arg, params)
+	}
+
+	f, ok := c.p.macros[name]
+	if !ok || f == nil {
+		return c.errorf("unknown macro: %s", name)
+	}
+
+	f(arg)
+	return nil
+}
+
+// include parses the files matching path into the object o.
+func (c *chunkParser) include(
+	o *object, path string, try bool, params map[string]string) error {
+	ok, err := c.p.limits.include(c.depth+1, c.pos())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return c.errorf("includes are nested too deeply: %s", path)
+	}
+
+	if v, ok := params["try"]; ok {
+		try, _ = strconv.ParseBool(v)
+	}
+
+	priority := c.priority
+	if v, ok := params["priority"]; ok {
+		p, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return c.errorf("invalid include priority: %s", v)
+		}
+		priority = uint(p)
+	}
+
+	paths := []string{path}
+	if glob, _ := strconv.ParseBool(params["glob"]); glob {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return c.errorf("invalid include pattern: %s", path)
+		}
+		if len(matches) == 0 && !try {
+			return c.errorf("cannot match any files for pattern %s", path)
+		}
+		paths = matches
+	}
+
+	for _, path := range paths {
+		src, err := c.p.limits.readFile(path)
+		if err != nil {
+			if try {
+				continue
+			}
+
+			return c.errorf("cannot open file %s: %s", path, err)
+		}
+		err = c.p.limits.addBytes(len(src), Position{Filename: path})
+		if err != nil {
+			return err
+		}
+
+		// The file variables are for the included file while it is
+		// being parsed.
+		filename, ok := c.p.variables["FILENAME"]
+		curdir := c.p.variables["CURDIR"]
+		c.p.setFileVariables(path)
+
+		inner := c.p.newChunkParser(src, path, priority, c.depth+1)
+		inner.top = c.top
+		inner.level = c.level
+		err = inner.parseInto(o)
+
+		if ok {
+			c.p.variables["FILENAME"] = filename
+			c.p.variables["CURDIR"] = curdir
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setPriority sets the priority of the values after it in the chunk to
+// the argument, or to the priority parameter.
+func (c *chunkParser) setPriority(arg string, params map[string]string) error {
+	v, ok := params["priority"]
+	if arg != "" {
+		v, ok = arg, true
+	}
+	if !ok {
+		return c.errorf("unable to parse priority macro")
+	}
+
+	p, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return c.errorf("invalid priority value in macro: %s", v)
+	}
+
+	c.priority = uint(p)
+	return nil
+}
+
+// inherit copies the values of the top-level object named by key into
+// the object o, except for the keys o already has.
+func (c *chunkParser) inherit(o *object, key string) error {
+	parent, ok := c.top.index[key]
+	if !ok || parent.typ != ObjectTypeObject {
+		return c.errorf("unable to find inherited object %s", key)
+	}
+	if o.typ != ObjectTypeObject {
+		return c.errorf("invalid inherit context")
+	}
+
+	for _, v := range parent.keys {
+		if _, ok := o.index[v.key]; !ok {
+			o.insert(v.key, v.copy())
+		}
+	}
+
+	return nil
+}
+
+// load sets the key parameter of the object o to the contents of the
+// file at path, as a string or, if the target parameter is "int", as an
+// integer.
+func (c *chunkParser) load(o *object, path string, params map[string]string) error {
+	key := params["key"]
+	if key == "" {
+		return c.errorf("no key specified in load macro")
+	}
+	try, _ := strconv.ParseBool(params["try"])
+	multiline, _ := strconv.ParseBool(params["multiline"])
+
+	var priority uint
+	if v, ok := params["priority"]; ok {
+		p, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return c.errorf("invalid load priority: %s", v)
+		}
+		priority = uint(p)
+	}
+
+	src, err := c.p.limits.readFile(path)
+	if err != nil {
+		if try {
+			return nil
+		}
+
+		return c.errorf("cannot open file %s: %s", path, err)
+	}
+	err = c.p.limits.addBytes(len(src), Position{Filename: path})
+	if err != nil {
+		return err
+	}
+
+	if _, ok := o.index[key]; ok {
+		return c.errorf("key %s already exists", key)
+	}
+
+	var v *object
+	switch strings.ToLower(params["target"]) {
+	case "", "string":
+		v = newObject(ObjectTypeString)
+		v.str = string(src)
+		v.multiline = multiline
+	case "int":
+		// Like strtoll, only the number at the start is used.
+		s := strings.TrimLeft(string(src), " \t\r\n")
+		end := 0
+		for end < len(s) && (s[end] >= '0' && s[end] <= '9' ||
+			end == 0 && (s[end] == '-' || s[end] == '+')) {
+			end++
+		}
+		v = newObject(ObjectTypeInt)
+		v.integer, _ = strconv.ParseInt(s[:end], 10, 64)
+	default:
+		return nil
+	}
+
+	v.priority = priority
+	o.insert(key, v)
+	return nil
+}
+
+// parseNumberAtom parses an atom as a number with an optional libucl
+// suffix. It returns false if the atom isn't a number.
+func parseNumberAtom(atom string, noTime bool) (*object, bool, error) {
+	s := atom
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg = true
+		s = s[1:]
+	}
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return nil, false, nil
+	}
+
+	// Hex numbers are always integers without a suffix.
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		u, err := strconv.ParseUint(s[2:], 16, 64)
+		if err != nil {
+			if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+				return nil, true, fmt.Errorf("numeric value out of range")
+			}
+			return nil, false, nil
+		}
+
+		v := newObject(ObjectTypeInt)
+		v.integer = int64(u)
+		if neg {
+			v.integer = -v.integer
+		}
+		return v, true, nil
+	}
+
+	// Find the end of the number itself.
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	isFloat := false
+	if i < len(s) && s[i] == '.' {
+		isFloat = true
+		i++
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			isFloat = true
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			i = j
+		}
+	}
+
+	num := atom[:len(atom)-len(s)+i]
+	mult, isTime, ok := numberAtomSuffix(strings.ToLower(s[i:]))
+	if !ok || (isTime && noTime) {
+		return nil, false, nil
+	}
+
+	if isFloat || isTime {
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return nil, true, fmt.Errorf("numeric value out of range")
+		}
+
+		v := newObject(ObjectTypeFloat)
+		if isTime {
+			v.typ = ObjectTypeTime
+		}
+		v.float = f * mult
+		return v, true, nil
+	}
+
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return nil, true, fmt.Errorf("numeric value out of range")
+	}
+
+	v := newObject(ObjectTypeInt)
+	v.integer = n * int64(mult)
+	return v, true, nil
+}
+
+// numberAtomSuffix returns the multiplier for the suffix of a number,
+// and whether it makes the number a time.
+func numberAtomSuffix(suffix string) (float64, bool, bool) {
+	switch suffix {
+	case "":
+		return 1, false, true
+	case "k", "m", "g", "kb", "mb", "gb":
+	case "ms", "min", "s", "h", "d", "w", "y":
+	default:
+		return 0, false, false
+	}
+
+	for _, ns := range numberSuffixes {
+		if ns.suffix == suffix {
+			return ns.mult, ns.time, true
+		}
+	}
+
+	return 0, false, false
+}
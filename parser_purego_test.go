@@ -0,0 +1,128 @@
+//go:build purego
+// +build purego
+
+package libucl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParser_errors(t *testing.T) {
+	cases := []struct {
+		Input string
+		Error string
+	}{
+		{"foo = {", "line: 1, column: 7 - 'unfinished object'"},
+		{"foo = [1, 2", "line: 1, column: 7 - 'unfinished array'"},
+		{"foo = \"bar", "at the end of chunk: unfinished string"},
+		{"foo = \"bar\" baz", "line: 1, column: 13 - 'delimiter is missing'"},
+		{"foo = 9223372036854775808", "line: 1, column: 7 - 'numeric value out of range'"},
+		{"foo = bar; }", "line: 1, column: 12 - 'unexpected closing bracket'"},
+		{"/* foo", "line: 1, column: 1 - 'unfinished multiline comment'"},
+		{".unknown foo", "unknown macro: unknown"},
+	}
+
+	for _, tc := range cases {
+		_, err := ParseString(tc.Input)
+		if err == nil {
+			t.Fatalf("input: %s\n\nshould error", tc.Input)
+		}
+		if !strings.Contains(err.Error(), tc.Error) {
+			t.Fatalf("input: %s\n\nbad: %s", tc.Input, err)
+		}
+	}
+}
+
+func TestParser_flags(t *testing.T) {
+	p := NewParser(ParserKeyLowercase | ParserNoTime)
+	defer p.Close()
+
+	if err := p.AddString("FOO = 10s; Bar = 10k;"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	if v.Type() != ObjectTypeString || v.ToString() != "10s" {
+		t.Fatalf("bad: %#v", v.ToString())
+	}
+
+	v = obj.Get("bar")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	if v.Type() != ObjectTypeInt || v.ToInt() != 10000 {
+		t.Fatalf("bad: %#v", v.ToInt())
+	}
+}
+
+func TestParser_includeRecursive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "loop.conf")
+	data := []byte(".include \"$CURDIR/loop.conf\"\n")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p := NewParser(0)
+	defer p.Close()
+
+	err = p.AddFile(path)
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if !strings.Contains(err.Error(), "nested too deeply") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParserAddMsgpack_types(t *testing.T) {
+	obj := testParseString(t, `
+	small = 1; byte = 200; large = 100000; huge = 10000000000;
+	negative = -1; negativeLarge = -100000;
+	float = 1.5; yes = true; no = false; nothing = null;
+	short = "foo"; long = "`+strings.Repeat("x", 300)+`";
+	array = [1, [2], {a = 3}];
+	`)
+	defer obj.Close()
+
+	data, err := obj.Emit(EmitMsgpack)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p := NewParser(0)
+	defer p.Close()
+	if err := p.AddMsgpack([]byte(data)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj2 := p.Object()
+	defer obj2.Close()
+
+	expected, err := obj.Emit(EmitJSONCompact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	actual, err := obj2.Emit(EmitJSONCompact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual != expected {
+		t.Fatalf("bad: %s\n\nexpected: %s", actual, expected)
+	}
+}
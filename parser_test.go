@@ -1,11 +1,14 @@
 package libucl
 
 import (
+	"fmt"
 	"io/ioutil"
+	"reflect"
+	"sync"
 	"testing"
 )
 
-func testParseString(t *testing.T, data string) *Object {
+func testParseString(t testing.TB, data string) *Object {
 	obj, err := ParseString(data)
 	if err != nil {
 		t.Fatalf("err: %s", err)
@@ -95,6 +98,121 @@ func TestParserAddFile(t *testing.T) {
 	}
 }
 
+func TestParserAddStringWithPriority(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddStringWithPriority(`foo = bar;`, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.AddStringWithPriority(`foo = baz;`, 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.AddStringWithPriority(`bar = baz;`, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	value := obj.Get("foo")
+	if value == nil {
+		t.Fatal("should have value")
+	}
+	defer value.Close()
+
+	if value.ToString() != "bar" {
+		t.Fatalf("bad: %#v", value.ToString())
+	}
+	if value.Priority() != 2 {
+		t.Fatalf("bad: %#v", value.Priority())
+	}
+}
+
+func TestParserAddFileWithPriority(t *testing.T) {
+	tf, err := ioutil.TempFile("", "libucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tf.Write([]byte("foo = baz;"))
+	tf.Close()
+
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddString(`foo = bar;`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.AddFileWithPriority(tf.Name(), 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	value := obj.Get("foo")
+	if value == nil {
+		t.Fatal("should have value")
+	}
+	defer value.Close()
+
+	if value.ToString() != "baz" {
+		t.Fatalf("bad: %#v", value.ToString())
+	}
+}
+
+func TestParserAddMsgpack(t *testing.T) {
+	obj := testParseString(t, `foo = bar; bar = [1, 2.5, true]; baz { qux = "boo"; }`)
+	defer obj.Close()
+
+	expected, err := obj.Emit(EmitJSON)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := obj.Emit(EmitMsgpack)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddMsgpack([]byte(data)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj2 := p.Object()
+	if obj2 == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj2.Close()
+
+	result, err := obj2.Emit(EmitJSON)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != expected {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestParserAddMsgpack_invalid(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddMsgpack([]byte{0xc1}); err == nil {
+		t.Fatal("should error")
+	}
+}
+
 func TestParserRegisterMacro(t *testing.T) {
 	value := ""
 	macro := func(data string) {
@@ -117,6 +235,95 @@ func TestParserRegisterMacro(t *testing.T) {
 	}
 }
 
+func TestParserDisableMacro(t *testing.T) {
+	called := false
+	p := NewParser(ParserDisableMacro | ParserSaveComments)
+	defer p.Close()
+
+	p.RegisterMacro("foo", func(string) { called = true })
+
+	err := p.AddString(".include \"/nonexistent\"\n.foo \"bar\"\na = 1;")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if called {
+		t.Fatal("macro should not be called")
+	}
+
+	obj := p.Object()
+	defer obj.Close()
+
+	a := obj.Get("a")
+	defer a.Close()
+
+	comments := a.Comments()
+	expected := []string{`.include "/nonexistent"`, `.foo "bar"`}
+	if !reflect.DeepEqual(comments, expected) {
+		t.Fatalf("bad: %#v", comments)
+	}
+}
+
+func TestParserNoFileVars(t *testing.T) {
+	p := NewParser(ParserNoFileVars)
+	defer p.Close()
+
+	if err := p.AddString(`a = "$CURDIR/$FILENAME";`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	defer obj.Close()
+
+	a := obj.Get("a")
+	defer a.Close()
+
+	if a.ToString() != "$CURDIR/$FILENAME" {
+		t.Fatalf("bad: %#v", a.ToString())
+	}
+}
+
+func TestParserRegisterMacro_concurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var values []string
+			p := NewParser(0)
+			defer p.Close()
+			p.RegisterMacro("foo", func(data string) {
+				values = append(values, data)
+			})
+
+			for j := 0; j < 10; j++ {
+				config := fmt.Sprintf(`.foo "%d-%d";`, i, j)
+				if err := p.AddString(config); err != nil {
+					errs <- err
+					return
+				}
+			}
+
+			for j, v := range values {
+				if expected := fmt.Sprintf("%d-%d", i, j); v != expected {
+					errs <- fmt.Errorf("bad: %s != %s", v, expected)
+					return
+				}
+			}
+			if len(values) != 10 {
+				errs <- fmt.Errorf("bad: %#v", values)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("err: %s", err)
+	}
+}
+
 func TestParseString(t *testing.T) {
 	obj, err := ParseString("foo = bar; baz = boo;")
 	if err != nil {
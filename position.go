@@ -0,0 +1,34 @@
+package libucl
+
+import (
+	"fmt"
+)
+
+// Position is a location in a configuration source.
+type Position struct {
+	Filename string // empty if the source was a string
+	Line     int    // starting at 1
+	Column   int    // starting at 1, in bytes
+}
+
+// IsValid returns true if the position is known.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position in the form "file:line:column", leaving
+// out the parts that aren't known.
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+
+	return s
+}
@@ -0,0 +1,191 @@
+package libucl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPositionString(t *testing.T) {
+	cases := []struct {
+		Pos      Position
+		Expected string
+	}{
+		{Position{}, "-"},
+		{Position{Filename: "foo.conf"}, "foo.conf"},
+		{Position{Line: 3, Column: 5}, "3:5"},
+		{Position{Filename: "foo.conf", Line: 3, Column: 5}, "foo.conf:3:5"},
+	}
+
+	for _, tc := range cases {
+		if actual := tc.Pos.String(); actual != tc.Expected {
+			t.Fatalf("bad: %#v %#v", tc.Pos, actual)
+		}
+	}
+}
+
+// testParsePositions parses data with a parser that saves positions.
+func testParsePositions(t *testing.T, data string) *Object {
+	p := NewParser(ParserSavePositions)
+	defer p.Close()
+	if err := p.AddString(data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return p.Object()
+}
+
+func TestObjectPosition(t *testing.T) {
+	obj := testParsePositions(t, `foo = bar;
+section "name" {
+  # comment
+  key = [1,
+    { inner = true; }];
+}
+foo = baz;
+`)
+	defer obj.Close()
+
+	cases := []struct {
+		Path string
+		Line int
+		Col  int
+	}{
+		{"section", 2, 1},
+		{"section.name", 2, 9},
+		{"section.name.key", 4, 3},
+		{"section.name.key.0", 4, 10},
+		{"section.name.key.1", 5, 5},
+		{"section.name.key.1.inner", 5, 7},
+	}
+
+	for _, tc := range cases {
+		v := obj.LookupPath(tc.Path)
+		if v == nil {
+			t.Fatalf("should find: %s", tc.Path)
+		}
+
+		pos := v.Position()
+		v.Close()
+		if pos.Line != tc.Line || pos.Column != tc.Col {
+			t.Fatalf("bad %s: %s", tc.Path, pos)
+		}
+	}
+
+	pos := obj.Position()
+	if pos.Line != 1 || pos.Column != 1 {
+		t.Fatalf("bad: %s", pos)
+	}
+
+	// Repeated keys each have their own position
+	foo := obj.Get("foo")
+	defer foo.Close()
+
+	var lines []int
+	iter := foo.Iterate(false)
+	defer iter.Close()
+	for v := iter.Next(); v != nil; v = iter.Next() {
+		lines = append(lines, v.Position().Line)
+		v.Close()
+	}
+	if len(lines) != 2 || lines[0] != 1 || lines[1] != 7 {
+		t.Fatalf("bad: %#v", lines)
+	}
+}
+
+func TestObjectPosition_file(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	included := filepath.Join(dir, "included.conf")
+	if err := ioutil.WriteFile(included, []byte("\n  bar = baz;\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	path := filepath.Join(dir, "main.conf")
+	data := []byte("foo = bar;\n.include \"$CURDIR/included.conf\"\n")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p := NewParser(ParserSavePositions)
+	defer p.Close()
+	if err := p.AddFile(path); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	defer v.Close()
+	expected := Position{Filename: path, Line: 1, Column: 1}
+	if v.Position() != expected {
+		t.Fatalf("bad: %s", v.Position())
+	}
+
+	v2 := obj.Get("bar")
+	if v2 == nil {
+		t.Fatal("should find")
+	}
+	defer v2.Close()
+	expected = Position{Filename: included, Line: 2, Column: 3}
+	if v2.Position() != expected {
+		t.Fatalf("bad: %s", v2.Position())
+	}
+}
+
+func TestObjectPosition_priority(t *testing.T) {
+	p := NewParser(ParserSavePositions)
+	defer p.Close()
+
+	if err := p.AddString("foo = bar;"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.AddStringWithPriority("\n\nfoo = baz;", 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	defer v.Close()
+	if v.Position().Line != 3 {
+		t.Fatalf("bad: %s", v.Position())
+	}
+}
+
+func TestObjectPosition_disabled(t *testing.T) {
+	obj := testParseString(t, "foo = bar;")
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	defer v.Close()
+	if v.Position().IsValid() {
+		t.Fatalf("bad: %s", v.Position())
+	}
+}
+
+func TestObjectPosition_set(t *testing.T) {
+	obj := testParsePositions(t, "foo { bar = 1; }\nbaz = 2;\n")
+	defer obj.Close()
+
+	obj.Delete("baz")
+	for _, key := range []string{"foo", "baz"} {
+		value := NewString("value")
+		obj.Set(key, value)
+		value.Close()
+
+		v := obj.Get(key)
+		pos := v.Position()
+		v.Close()
+		if pos.IsValid() {
+			t.Fatalf("bad %s: %s", key, pos)
+		}
+	}
+}
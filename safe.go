@@ -0,0 +1,198 @@
+package libucl
+
+import (
+	"io"
+	"sync"
+)
+
+// SafeObject is a read-only view of an Object that can be used by
+// multiple goroutines at once.
+//
+// An Object can't be shared between goroutines, even just for reading:
+// libucl doesn't use atomic reference counts, and reading some values
+// (such as keys) caches them in the object. A SafeObject and all of the
+// SafeObjects it returns share a lock that is held for every call into
+// libucl, so they can be shared freely. Each one must still be closed
+// once, by any goroutine.
+type SafeObject struct {
+	lock   *sync.Mutex
+	object *Object
+}
+
+// SafeObjectIter is an iterator for SafeObjects. Unlike the SafeObjects
+// it returns, an iterator must only be used by one goroutine at a time.
+type SafeObjectIter struct {
+	lock *sync.Mutex
+	iter *ObjectIter
+}
+
+// NewSafeObject returns a SafeObject for o, which takes over the
+// caller's reference to o. Once the SafeObject is created, o must not be
+// used or closed; close the SafeObject instead.
+func NewSafeObject(o *Object) *SafeObject {
+	return &SafeObject{lock: new(sync.Mutex), object: o}
+}
+
+func (o *SafeObject) wrap(obj *Object) *SafeObject {
+	if obj == nil {
+		return nil
+	}
+
+	return &SafeObject{lock: o.lock, object: obj}
+}
+
+// Close frees the reference to the object. It must be called once for
+// every SafeObject.
+func (o *SafeObject) Close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.Close()
+}
+
+// Comments is the same as Object.Comments.
+func (o *SafeObject) Comments() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.Comments()
+}
+
+// Decode is the same as Object.Decode. The lock is held while decoding,
+// so the objects decoded into fields tagged with "object" must not be
+// used by other goroutines.
+func (o *SafeObject) Decode(v interface{}) error {
+	return o.DecodeWithOptions(v, nil)
+}
+
+// DecodeWithOptions is the same as Object.DecodeWithOptions.
+func (o *SafeObject) DecodeWithOptions(v interface{}, opts *DecodeOptions) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.DecodeWithOptions(v, opts)
+}
+
+// Emit is the same as Object.Emit.
+func (o *SafeObject) Emit(t Emitter) (string, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.Emit(t)
+}
+
+// EmitTo is the same as Object.EmitTo. The lock is held while writing to
+// w.
+func (o *SafeObject) EmitTo(w io.Writer, t Emitter) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.EmitTo(w, t)
+}
+
+// Get is the same as Object.Get.
+func (o *SafeObject) Get(key string) *SafeObject {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.wrap(o.object.Get(key))
+}
+
+// Iterate is the same as Object.Iterate.
+func (o *SafeObject) Iterate(expand bool) *SafeObjectIter {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return &SafeObjectIter{lock: o.lock, iter: o.object.Iterate(expand)}
+}
+
+// Key is the same as Object.Key.
+func (o *SafeObject) Key() string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.Key()
+}
+
+// Len is the same as Object.Len.
+func (o *SafeObject) Len() uint {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.Len()
+}
+
+// LineComment is the same as Object.LineComment.
+func (o *SafeObject) LineComment() string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.LineComment()
+}
+
+// LookupPath is the same as Object.LookupPath.
+func (o *SafeObject) LookupPath(path string) *SafeObject {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.wrap(o.object.LookupPath(path))
+}
+
+// Position is the same as Object.Position.
+func (o *SafeObject) Position() Position {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.Position()
+}
+
+// Priority is the same as Object.Priority.
+func (o *SafeObject) Priority() uint {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.Priority()
+}
+
+// TrailingComments is the same as Object.TrailingComments.
+func (o *SafeObject) TrailingComments() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.TrailingComments()
+}
+
+// Type is the same as Object.Type.
+func (o *SafeObject) Type() ObjectType {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.Type()
+}
+
+func (o *SafeObject) ToBool() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.ToBool()
+}
+
+func (o *SafeObject) ToInt() int64 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.ToInt()
+}
+
+func (o *SafeObject) ToFloat() float64 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.ToFloat()
+}
+
+func (o *SafeObject) ToString() string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.object.ToString()
+}
+
+func (o *SafeObjectIter) Close() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.iter.Close()
+}
+
+func (o *SafeObjectIter) Next() *SafeObject {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	obj := o.iter.Next()
+	if obj == nil {
+		return nil
+	}
+
+	return &SafeObject{lock: o.lock, object: obj}
+}
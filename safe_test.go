@@ -0,0 +1,98 @@
+package libucl
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSafeObject(t *testing.T) {
+	obj := testParseString(t, `foo = bar; servers = [{ port = 80; }];`)
+	safe := NewSafeObject(obj)
+	defer safe.Close()
+
+	foo := safe.Get("foo")
+	defer foo.Close()
+	if foo.Key() != "foo" || foo.ToString() != "bar" {
+		t.Fatalf("bad: %s = %s", foo.Key(), foo.ToString())
+	}
+
+	port := safe.LookupPath("servers.0.port")
+	defer port.Close()
+	if port.ToInt() != 80 {
+		t.Fatalf("bad: %d", port.ToInt())
+	}
+
+	if v := safe.Get("nope"); v != nil {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestSafeObject_concurrent(t *testing.T) {
+	type Server struct {
+		Port int
+	}
+
+	type Config struct {
+		Name    string
+		Servers []Server
+	}
+
+	obj := testParseString(t, `
+	name = foo;
+	servers = [{ port = 80; }, { port = 81; }];
+	tags { a = 1; b = 2; c = 3; }
+	`)
+	safe := NewSafeObject(obj)
+	defer safe.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 20; j++ {
+				name := safe.Get("name")
+				if v := name.ToString(); v != "foo" {
+					errs <- fmt.Errorf("bad: %s", v)
+				}
+				name.Close()
+
+				var count int
+				tags := safe.Get("tags")
+				iter := tags.Iterate(true)
+				for elem := iter.Next(); elem != nil; elem = iter.Next() {
+					if elem.Key() == "" {
+						errs <- fmt.Errorf("bad key")
+					}
+					elem.Close()
+					count++
+				}
+				iter.Close()
+				tags.Close()
+				if count != 3 {
+					errs <- fmt.Errorf("bad: %d", count)
+				}
+
+				var config Config
+				if err := safe.Decode(&config); err != nil {
+					errs <- err
+				} else if len(config.Servers) != 2 || config.Servers[1].Port != 81 {
+					errs <- fmt.Errorf("bad: %#v", config)
+				}
+
+				if _, err := safe.Emit(EmitJSONCompact); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -0,0 +1,908 @@
+//go:build !purego
+// +build !purego
+
+package libucl
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// libucl doesn't record where objects come from, so a parser created
+// with ParserSavePositions scans every chunk it is given for the position
+// of each key and array element. Once parsing is done, the positions are
+// matched up with the parsed objects by their path, in the order they
+// appear.
+//
+// The scanner only understands as much of the UCL grammar as it needs
+// to find keys. Where macros may have created or replaced objects, or
+// where the scanner finds something it doesn't understand, the objects
+// aren't given a position rather than possibly a wrong one. A position
+// is also only used if the kind of value the scanner found there matches
+// the parsed object.
+
+// pathSep separates the parts of a path in a positionIndex. It can't
+// appear in a key.
+const pathSep = "\x00"
+
+// scannedPosition is a position found by the scanner along with the
+// priority of the chunk it was found in, and the kind of value there.
+type scannedPosition struct {
+	pos      Position
+	priority uint
+	kind     valueKind
+}
+
+// valueKind is the kind of value the scanner found, which is checked
+// against the parsed object before its position is used.
+type valueKind int
+
+const (
+	kindUnknown valueKind = iota
+	kindObject
+	kindArray
+	kindString // quoted or a heredoc
+	kindAtom   // bare, which can be any scalar
+)
+
+// matches returns true if a value of kind k can be parsed as type t.
+func (k valueKind) matches(t ObjectType) bool {
+	switch k {
+	case kindObject:
+		return t == ObjectTypeObject
+	case kindArray:
+		return t == ObjectTypeArray
+	case kindString:
+		return t == ObjectTypeString
+	case kindAtom:
+		return t != ObjectTypeObject && t != ObjectTypeArray
+	default:
+		return false
+	}
+}
+
+// scannedRef refers to the i-th position found for a path.
+type scannedRef struct {
+	path string
+	i    int
+}
+
+// scannedLineComment is the comments at the end of the line the value
+// owner ended on. libucl attaches them to the next value, or if owner
+// was the last value in its object or array, after owner.
+type scannedLineComment struct {
+	owner    scannedRef
+	next     *scannedRef
+	comments []string
+}
+
+// positionIndex maps the path of a key or array element to all the
+// positions it was found at, in order.
+type positionIndex struct {
+	positions    map[string][]scannedPosition
+	lineComments []*scannedLineComment
+
+	// untracked are the paths of objects whose contents the scanner
+	// couldn't follow. Nothing below them has a position.
+	untracked map[string]bool
+}
+
+func newPositionIndex() *positionIndex {
+	return &positionIndex{
+		positions: make(map[string][]scannedPosition),
+		untracked: make(map[string]bool),
+	}
+}
+
+// tracked returns true if no path above the path is untracked.
+func (idx *positionIndex) tracked(path string) bool {
+	if idx.untracked[""] {
+		return false
+	}
+
+	for i := 0; i < len(path); i++ {
+		if path[i] == pathSep[0] && idx.untracked[path[:i]] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// next returns the next unused position for the path, for a value of
+// type t. If priority isn't negative, positions from chunks with a
+// different priority are skipped, since libucl will have ignored or
+// replaced those values. If the value found there doesn't match t, the
+// scanner and libucl disagree, so the position isn't used.
+func (idx *positionIndex) next(
+	path string, t ObjectType, priority int,
+	cursors map[string]int) (scannedRef, bool) {
+	if !idx.tracked(path) {
+		return scannedRef{}, false
+	}
+
+	list := idx.positions[path]
+	for i := cursors[path]; i < len(list); i++ {
+		if priority < 0 || list[i].priority == uint(priority) {
+			cursors[path] = i + 1
+			return scannedRef{path: path, i: i}, list[i].kind.matches(t)
+		}
+	}
+
+	return scannedRef{}, false
+}
+
+func (idx *positionIndex) position(ref scannedRef) Position {
+	return idx.positions[ref.path][ref.i].pos
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + pathSep + key
+}
+
+// macroParamRe matches the parameters of a macro and their values.
+var macroParamRe = regexp.MustCompile(`([A-Za-z_]+)\s*=\s*"?([^",\s]*)`)
+
+// positionScanner finds the positions of keys and array elements in a
+// single chunk.
+type positionScanner struct {
+	src      []byte
+	off      int
+	line     int
+	col      int
+	filename string
+	priority uint
+
+	flags     ParserFlag
+	variables map[string]string
+	depth     int
+	index     *positionIndex
+
+	// level is how deeply the object or array being scanned is nested,
+	// where the top object has a level of 0.
+	level int
+
+	// last is the value that was scanned last, which ended on lastLine,
+	// and comment is the line comment after it that is still waiting
+	// for the next value. peeking is set while looking ahead, when
+	// nothing is recorded.
+	last     *scannedRef
+	lastLine int
+	comment  *scannedLineComment
+	peeking  bool
+
+	// limits, if not nil, are checked for the values and the files that
+	// are included instead of recording positions.
+	limits *parseLimits
+}
+
+// scanPositions scans src for positions and adds them to index. The
+// variables are the ones registered with the parser.
+func scanPositions(
+	index *positionIndex, src []byte, filename string,
+	priority uint, flags ParserFlag, variables map[string]string) {
+	s := &positionScanner{
+		src:       src,
+		line:      1,
+		col:       1,
+		filename:  filename,
+		priority:  priority,
+		flags:     flags,
+		variables: variables,
+		index:     index,
+	}
+	s.scan()
+}
+
+// scan scans the whole chunk.
+func (s *positionScanner) scan() {
+	s.skipSpace(true)
+	switch s.peek() {
+	case '{':
+		s.advance()
+		s.scanObject("", '}')
+	case '[':
+		s.advance()
+		s.scanArray("")
+	default:
+		s.scanObject("", 0)
+	}
+}
+
+func (s *positionScanner) eof() bool {
+	return s.off >= len(s.src)
+}
+
+func (s *positionScanner) peek() byte {
+	if s.eof() {
+		return 0
+	}
+
+	return s.src[s.off]
+}
+
+func (s *positionScanner) peekAt(n int) byte {
+	if s.off+n >= len(s.src) {
+		return 0
+	}
+
+	return s.src[s.off+n]
+}
+
+func (s *positionScanner) advance() {
+	if s.eof() {
+		return
+	}
+
+	if s.src[s.off] == '\n' {
+		s.line++
+		s.col = 1
+	} else {
+		s.col++
+	}
+	s.off++
+}
+
+func (s *positionScanner) pos() Position {
+	return Position{Filename: s.filename, Line: s.line, Column: s.col}
+}
+
+func (s *positionScanner) record(path string, pos Position) scannedRef {
+	if s.limits != nil {
+		return scannedRef{}
+	}
+
+	ref := scannedRef{path: path, i: len(s.index.positions[path])}
+	s.index.positions[path] = append(s.index.positions[path], scannedPosition{
+		pos:      pos,
+		priority: s.priority,
+	})
+
+	if s.comment != nil {
+		s.comment.next = &ref
+		s.comment = nil
+	}
+
+	return ref
+}
+
+// setKind sets the kind of value found at ref.
+func (s *positionScanner) setKind(ref scannedRef, kind valueKind) {
+	if s.limits != nil {
+		return
+	}
+
+	s.index.positions[ref.path][ref.i].kind = kind
+}
+
+// ended records that the value at ref ended on the current line.
+func (s *positionScanner) ended(ref scannedRef) {
+	s.last = &ref
+	s.lastLine = s.line
+}
+
+// endContainer is called at the start and end of an object or array.
+// A line comment still waiting for the next value ends up after the
+// value it follows.
+func (s *positionScanner) endContainer() {
+	s.last = nil
+	s.comment = nil
+}
+
+// saveComment saves the comment from start to the current offset, which
+// started on line, if it is on the line the last value ended on.
+func (s *positionScanner) saveComment(start, line int) {
+	if s.limits != nil || s.peeking || s.last == nil || line != s.lastLine {
+		return
+	}
+
+	comment := strings.TrimRight(string(s.src[start:s.off]), "\r")
+	if s.comment == nil || s.comment.owner != *s.last {
+		s.comment = &scannedLineComment{owner: *s.last}
+		s.index.lineComments = append(s.index.lineComments, s.comment)
+	}
+	s.comment.comments = append(s.comment.comments, comment)
+}
+
+// checkDepth checks the level being scanned against the limits.
+func (s *positionScanner) checkDepth(pos Position) {
+	if s.limits != nil {
+		s.limits.checkDepth(s.level, pos)
+	}
+}
+
+// untrack marks the object at path as one whose contents can't be
+// followed by the scanner.
+func (s *positionScanner) untrack(path string) {
+	if s.limits != nil {
+		return
+	}
+
+	s.index.untracked[path] = true
+}
+
+// skipSpace skips whitespace and comments. If newlines is false, it stops
+// at the end of the line.
+func (s *positionScanner) skipSpace(newlines bool) {
+	for !s.eof() {
+		c := s.peek()
+		switch {
+		case c == '\n' && !newlines:
+			return
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			s.advance()
+		case c == '#':
+			start, line := s.off, s.line
+			for !s.eof() && s.peek() != '\n' {
+				s.advance()
+			}
+			s.saveComment(start, line)
+		case c == '/' && s.peekAt(1) == '*':
+			// Multi-line comments can be nested.
+			start, line := s.off, s.line
+			nesting := 0
+			for !s.eof() {
+				if s.peek() == '/' && s.peekAt(1) == '*' {
+					nesting++
+					s.advance()
+				} else if s.peek() == '*' && s.peekAt(1) == '/' {
+					nesting--
+					s.advance()
+					if nesting == 0 {
+						s.advance()
+						break
+					}
+				}
+				s.advance()
+			}
+			s.saveComment(start, line)
+		default:
+			return
+		}
+	}
+}
+
+// skipSeparators skips whitespace, comments and value separators.
+func (s *positionScanner) skipSeparators() {
+	for {
+		s.skipSpace(true)
+		if c := s.peek(); c != ',' && c != ';' {
+			return
+		}
+		s.advance()
+	}
+}
+
+// scanObject scans the keys of an object until the closing byte, or the
+// end of the source if closing is 0.
+func (s *positionScanner) scanObject(path string, closing byte) {
+	s.endContainer()
+	defer s.endContainer()
+
+	for {
+		s.skipSeparators()
+		if s.eof() || s.limits.failed() != nil {
+			return
+		}
+
+		c := s.peek()
+		switch {
+		case c == closing:
+			s.advance()
+			return
+		case c == '}' || c == ']':
+			// Unbalanced, libucl will have reported an error.
+			s.advance()
+			return
+		case c == '.' && isKeyStart(s.peekAt(1)):
+			s.scanMacro(path)
+			continue
+		}
+
+		pos := s.pos()
+		key, ok := s.scanToken()
+		if !ok {
+			// Not something we understand, so nothing in this object
+			// is given a position.
+			s.untrack(path)
+			s.advance()
+			continue
+		}
+
+		keyPath := joinPath(path, s.key(key))
+		ref := s.record(keyPath, pos)
+
+		level := s.level
+		s.level++
+		s.checkDepth(pos)
+
+		// A key can be followed by more keys on the same line to create
+		// nested objects, such as `bundle "foo" { ... }`.
+		last := ref
+		for {
+			s.skipSpace(false)
+			c := s.peek()
+			if c == '=' || c == ':' {
+				s.advance()
+				s.skipSpace(true)
+				s.setKind(last, s.scanValue(keyPath))
+				break
+			}
+			if c == '{' || c == '[' || !s.sectionFollows() {
+				s.setKind(last, s.scanValue(keyPath))
+				break
+			}
+
+			s.setKind(last, kindObject)
+			pos := s.pos()
+			key, _ := s.scanToken()
+			keyPath = joinPath(keyPath, s.key(key))
+			last = s.record(keyPath, pos)
+
+			s.level++
+			s.checkDepth(pos)
+		}
+		s.level = level
+		s.ended(ref)
+	}
+}
+
+// sectionFollows returns true if the next tokens on the line are the
+// keys of a nested section, which means they end with a "{".
+func (s *positionScanner) sectionFollows() bool {
+	saved := *s
+	defer func() { *s = saved }()
+
+	s.peeking = true
+	for {
+		if _, ok := s.scanToken(); !ok {
+			return false
+		}
+
+		s.skipSpace(false)
+		if s.peek() == '{' {
+			return true
+		}
+	}
+}
+
+func (s *positionScanner) scanArray(path string) {
+	s.endContainer()
+	defer s.endContainer()
+
+	i := 0
+	for {
+		s.skipSeparators()
+		if s.eof() || s.limits.failed() != nil {
+			return
+		}
+
+		switch s.peek() {
+		case ']':
+			s.advance()
+			return
+		case '}':
+			s.advance()
+			return
+		}
+
+		pos := s.pos()
+		elemPath := joinPath(path, strconv.Itoa(i))
+		ref := s.record(elemPath, pos)
+
+		s.level++
+		s.checkDepth(pos)
+		s.setKind(ref, s.scanValue(elemPath))
+		s.level--
+
+		s.ended(ref)
+		i++
+	}
+}
+
+// scanValue scans a value and returns its kind.
+func (s *positionScanner) scanValue(path string) valueKind {
+	switch c := s.peek(); {
+	case c == '{':
+		s.advance()
+		s.scanObject(path, '}')
+		return kindObject
+	case c == '[':
+		s.advance()
+		s.scanArray(path)
+		return kindArray
+	case c == '"' || c == '\'' || (c == '<' && s.peekAt(1) == '<'):
+		s.scanToken()
+		return kindString
+	default:
+		if !s.scanAtom() {
+			s.advance()
+			return kindUnknown
+		}
+		return kindAtom
+	}
+}
+
+// scanAtom scans a bare value the same way libucl does, which ends at a
+// separator, a comment, the end of the line, or a closing bracket that
+// doesn't close a bracket in the value itself, such as in ${NAME}. It
+// returns false if there is no value.
+func (s *positionScanner) scanAtom() bool {
+	start := s.off
+	braces, brackets := 0, 0
+	for !s.eof() {
+		c := s.peek()
+		if c == '}' {
+			if braces == 0 {
+				break
+			}
+			braces--
+		} else if c == ']' {
+			if brackets == 0 {
+				break
+			}
+			brackets--
+		} else if c == ';' || c == ',' || c == '\n' || c == '#' ||
+			(c == '/' && s.peekAt(1) == '*') {
+			break
+		} else if c == '{' {
+			braces++
+		} else if c == '[' {
+			brackets++
+		}
+		s.advance()
+	}
+
+	return s.off > start
+}
+
+// scanMacro skips over a macro and its argument. Includes are followed
+// so that the keys in the included file are found too.
+func (s *positionScanner) scanMacro(path string) {
+	pos := s.pos()
+	macroStart, macroLine := s.off, s.line
+	s.advance()
+	start := s.off
+	for !s.eof() && isKeyChar(s.peek()) {
+		s.advance()
+	}
+	name := string(s.src[start:s.off])
+
+	var params string
+	s.skipSpace(false)
+	if s.peek() == '(' {
+		start := s.off
+		for !s.eof() && s.peek() != ')' {
+			s.advance()
+		}
+		params = string(s.src[start:s.off])
+		s.advance()
+	}
+
+	s.skipSpace(false)
+	if s.peek() == '{' {
+		// The argument is a block, which isn't parsed as config.
+		nesting := 0
+		for !s.eof() {
+			switch s.peek() {
+			case '{':
+				nesting++
+			case '}':
+				nesting--
+			}
+			s.advance()
+			if nesting == 0 {
+				break
+			}
+		}
+		s.skipMacro(macroStart, macroLine)
+		return
+	}
+
+	arg, ok := s.scanToken()
+	if !ok {
+		// libucl reads the argument some other way, so what the macro
+		// does isn't known.
+		if s.flags&ParserDisableMacro == 0 {
+			s.failInclude(path,
+				fmt.Errorf("can't read the argument of .%s", name), pos)
+		}
+		return
+	}
+	if s.skipMacro(macroStart, macroLine) {
+		return
+	}
+
+	switch name {
+	case "include", "try_include":
+		s.scanInclude(path, arg, macroParams(params), pos)
+	case "load":
+		// .load reads a file into a string.
+		s.untrack(path)
+		s.scanLoad(arg, pos)
+	default:
+		// Other macros, such as .inherit and .priority, can create or
+		// change objects.
+		s.untrack(path)
+	}
+}
+
+// skipMacro returns true if macros are disabled, and saves the macro
+// from start to the current offset as a comment the same way libucl
+// does.
+func (s *positionScanner) skipMacro(start, line int) bool {
+	if s.flags&ParserDisableMacro == 0 {
+		return false
+	}
+
+	s.saveComment(start, line)
+	return true
+}
+
+// scanInclude follows an include, so that the keys in the included
+// files are found, or checked against the limits.
+func (s *positionScanner) scanInclude(
+	path, file string, params map[string]string, pos Position) {
+	if ok, _ := s.limits.include(s.depth+1, pos); !ok {
+		s.untrack(path)
+		return
+	}
+
+	// Patterns are only followed when checking limits, since the order
+	// of the positions in the files they match isn't known.
+	glob, _ := strconv.ParseBool(params["glob"])
+	if s.limits == nil && (glob || !includeTracked(params)) {
+		s.untrack(path)
+		return
+	}
+
+	file, ok := s.expandPath(file)
+	if !ok {
+		s.failInclude(path, fmt.Errorf("unknown variable in %s", file), pos)
+		return
+	}
+
+	files := []string{file}
+	if glob {
+		var err error
+		if files, err = filepath.Glob(file); err != nil {
+			s.failInclude(path, fmt.Errorf("invalid pattern %s", file), pos)
+			return
+		}
+	}
+
+	priority := s.priority
+	if v, ok := params["priority"]; ok {
+		if p, err := strconv.ParseUint(v, 10, 32); err == nil {
+			priority = uint(p)
+		}
+	}
+
+	for _, file := range files {
+		src, err := s.limits.readFile(file)
+		if err != nil {
+			// Without limits, a file that can't be read, such as for
+			// try_include, has nothing to find.
+			if s.limits != nil {
+				s.limits.failInclude(err, pos)
+				return
+			}
+			continue
+		}
+		if s.limits.addBytes(len(src), Position{Filename: file}) != nil {
+			return
+		}
+
+		inner := &positionScanner{
+			src:       src,
+			line:      1,
+			col:       1,
+			filename:  file,
+			priority:  priority,
+			flags:     s.flags,
+			variables: s.variables,
+			depth:     s.depth + 1,
+			index:     s.index,
+			level:     s.level,
+			limits:    s.limits,
+		}
+		inner.skipSpace(true)
+		if inner.peek() == '{' {
+			inner.advance()
+			inner.scanObject(path, '}')
+		} else {
+			inner.scanObject(path, 0)
+		}
+	}
+}
+
+// scanLoad counts the file loaded by a .load macro against the limits.
+func (s *positionScanner) scanLoad(file string, pos Position) {
+	if s.limits == nil {
+		return
+	}
+
+	file, ok := s.expandPath(file)
+	if !ok {
+		s.limits.failInclude(fmt.Errorf("unknown variable in %s", file), pos)
+		return
+	}
+
+	src, err := s.limits.readFile(file)
+	if err != nil {
+		s.limits.failInclude(err, pos)
+		return
+	}
+	s.limits.addBytes(len(src), Position{Filename: file})
+}
+
+// failInclude is called for an include that can't be followed because
+// of err. It fails when checking limits, and otherwise leaves the
+// objects at path without positions.
+func (s *positionScanner) failInclude(path string, err error, pos Position) {
+	if s.limits != nil {
+		s.limits.failInclude(err, pos)
+		return
+	}
+
+	s.untrack(path)
+}
+
+// expandPath expands the variables in the path of an included file the
+// same way libucl does. It returns false if the path has variables that
+// aren't known.
+func (s *positionScanner) expandPath(file string) (string, bool) {
+	variables := make(map[string]string, len(s.variables)+2)
+	for name, value := range s.variables {
+		variables[name] = value
+	}
+	if s.flags&ParserNoFileVars == 0 {
+		variables["FILENAME"], variables["CURDIR"] = fileVariables(s.filename)
+	}
+
+	return expandVariables(file, variables)
+}
+
+// macroParams returns the parameters of a macro, such as
+// `(priority=1, try=true)`, by name.
+func macroParams(params string) map[string]string {
+	result := make(map[string]string)
+	for _, m := range macroParamRe.FindAllStringSubmatch(params, -1) {
+		result[m[1]] = m[2]
+	}
+
+	return result
+}
+
+// includeTracked returns true if the parameters of an include leave the
+// included objects where the scanner expects them. Parameters such as
+// duplicate or prefix replace or move them.
+func includeTracked(params map[string]string) bool {
+	for name := range params {
+		if name != "priority" && name != "try" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// scanToken scans a single string, which may be quoted, a heredoc, or
+// bare. It returns false if there is no string at the current offset.
+func (s *positionScanner) scanToken() (string, bool) {
+	c := s.peek()
+	switch {
+	case c == '"' || c == '\'':
+		s.advance()
+		var buf []byte
+		for !s.eof() && s.peek() != c {
+			if s.peek() == '\\' {
+				s.advance()
+				if s.eof() {
+					break
+				}
+				buf = appendEscape(buf, s, c)
+				continue
+			}
+			buf = append(buf, s.peek())
+			s.advance()
+		}
+		s.advance()
+		return string(buf), true
+	case c == '<' && s.peekAt(1) == '<':
+		return s.scanHeredoc(), true
+	case isAtomChar(c):
+		start := s.off
+		for !s.eof() && isAtomChar(s.peek()) {
+			s.advance()
+		}
+		return string(s.src[start:s.off]), true
+	default:
+		return "", false
+	}
+}
+
+// appendEscape appends the escape sequence at the current offset, which
+// is just past the backslash, to buf.
+func appendEscape(buf []byte, s *positionScanner, quote byte) []byte {
+	c := s.peek()
+	s.advance()
+
+	if quote == '\'' {
+		if c != '\'' && c != '\\' {
+			buf = append(buf, '\\')
+		}
+		return append(buf, c)
+	}
+
+	switch c {
+	case 'n':
+		return append(buf, '\n')
+	case 'r':
+		return append(buf, '\r')
+	case 't':
+		return append(buf, '\t')
+	case 'b':
+		return append(buf, '\b')
+	case 'f':
+		return append(buf, '\f')
+	case 'u':
+		if s.off+4 <= len(s.src) {
+			v, err := strconv.ParseUint(string(s.src[s.off:s.off+4]), 16, 32)
+			if err == nil {
+				for i := 0; i < 4; i++ {
+					s.advance()
+				}
+				var r [utf8.UTFMax]byte
+				n := utf8.EncodeRune(r[:], rune(v))
+				return append(buf, r[:n]...)
+			}
+		}
+		return append(buf, c)
+	default:
+		return append(buf, c)
+	}
+}
+
+func (s *positionScanner) scanHeredoc() string {
+	s.advance()
+	s.advance()
+
+	start := s.off
+	for !s.eof() && s.peek() != '\n' {
+		s.advance()
+	}
+	term := string(s.src[start:s.off])
+	s.advance()
+
+	// The heredoc ends with the terminator on a line of its own.
+	bodyStart := s.off
+	for !s.eof() {
+		lineStart := s.off
+		for !s.eof() && s.peek() != '\n' {
+			s.advance()
+		}
+		if string(s.src[lineStart:s.off]) == term {
+			return string(s.src[bodyStart:lineStart])
+		}
+		s.advance()
+	}
+
+	return string(s.src[bodyStart:])
+}
+
+func (s *positionScanner) key(k string) string {
+	if s.flags&ParserKeyLowercase != 0 {
+		return strings.ToLower(k)
+	}
+
+	return k
+}
@@ -0,0 +1,147 @@
+//go:build !purego
+// +build !purego
+
+package libucl
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanPositions(t *testing.T) {
+	src := `
+/* a /* nested */ comment { */
+"quoted key": 'it\'s';
+text = <<EOD
+not { a key
+EOD
+after = 1
+`
+	idx := newPositionIndex()
+	scanPositions(idx, []byte(src), "", 0, 0, nil)
+
+	cases := map[string]Position{
+		"quoted key": {Line: 3, Column: 1},
+		"text":       {Line: 4, Column: 1},
+		"after":      {Line: 7, Column: 1},
+	}
+	for path, expected := range cases {
+		list := idx.positions[path]
+		if len(list) != 1 || list[0].pos != expected {
+			t.Fatalf("bad %s: %#v", path, list)
+		}
+	}
+
+	if len(idx.positions) != len(cases) {
+		t.Fatalf("bad: %#v", idx.positions)
+	}
+}
+
+func TestScanPositions_values(t *testing.T) {
+	src := "a = ${X}; X = foo{bar}-[1]\nurl = http://host:80/\nb = }\n"
+	idx := newPositionIndex()
+	scanPositions(idx, []byte(src), "", 0, 0, nil)
+
+	cases := []struct {
+		Path string
+		Type ObjectType
+		Pos  Position
+		OK   bool
+	}{
+		{"a", ObjectTypeString, Position{Line: 1, Column: 1}, true},
+		{"X", ObjectTypeString, Position{Line: 1, Column: 11}, true},
+		{"url", ObjectTypeString, Position{Line: 2, Column: 1}, true},
+		{"b", ObjectTypeString, Position{Line: 3, Column: 1}, false},
+	}
+	cursors := make(map[string]int)
+	for _, tc := range cases {
+		ref, ok := idx.next(tc.Path, tc.Type, -1, cursors)
+		if ok != tc.OK {
+			t.Fatalf("bad %s: %#v", tc.Path, ok)
+		}
+		if pos := idx.position(ref); pos != tc.Pos {
+			t.Fatalf("bad %s: %s", tc.Path, pos)
+		}
+	}
+
+	if len(idx.positions) != len(cases) {
+		t.Fatalf("bad: %#v", idx.positions)
+	}
+}
+
+func TestScanPositions_untracked(t *testing.T) {
+	src := `
+a { .inherit "b"; x = 1; }
+b { .include(duplicate="rewrite") "$CURDIR/b.conf"; y = 1; }
+c { .include(priority=1) "$CURDIR/missing.conf"; z = 1; }
+d = 1;
+`
+	idx := newPositionIndex()
+	scanPositions(idx, []byte(src), "", 0, 0, nil)
+
+	cases := map[string]bool{
+		"a":      true,
+		"a\x00x": false,
+		"b\x00y": false,
+		"c\x00z": true,
+		"d":      true,
+	}
+	for path, expected := range cases {
+		if actual := idx.tracked(path); actual != expected {
+			t.Fatalf("bad %q: %#v", path, actual)
+		}
+	}
+}
+
+func TestScanPositions_lineComments(t *testing.T) {
+	src := "a = 1; # about a\nb = [1, /* one */ # 1\n2 # two\n]\n# c\nc = 3;\n"
+	idx := newPositionIndex()
+	scanPositions(idx, []byte(src), "", 0, 0, nil)
+
+	var actual []string
+	for _, lc := range idx.lineComments {
+		next := "-"
+		if lc.next != nil {
+			next = strings.Replace(lc.next.path, pathSep, ".", -1)
+		}
+		actual = append(actual, fmt.Sprintf("%s %s %q",
+			strings.Replace(lc.owner.path, pathSep, ".", -1), next, lc.comments))
+	}
+
+	expected := []string{
+		`a b ["# about a"]`,
+		`b.0 b.1 ["/* one */" "# 1"]`,
+		`b.1 - ["# two"]`,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestScanLimits(t *testing.T) {
+	cases := []struct {
+		Input string
+		Limit Limit
+	}{
+		{`a { b { c = 1 } }`, LimitDepth},
+		{`a = [[1]]`, LimitDepth},
+		{`.include "${NOPE}/a.conf"`, LimitInclude},
+		{`.try_include "testdata/missing.conf"`, LimitInclude},
+		{`.load(key="a") "testdata/missing.conf"`, LimitInclude},
+	}
+
+	for _, tc := range cases {
+		p := NewParserWithOptions(&ParserOptions{MaxDepth: 2})
+		err := p.AddString(tc.Input)
+		p.Close()
+
+		// The position is only known if the scanner found the limit
+		// before libucl parsed the input.
+		limitErr, ok := err.(*LimitError)
+		if !ok || limitErr.Limit != tc.Limit || !limitErr.Pos.IsValid() {
+			t.Fatalf("input: %s\n\nbad: %#v", tc.Input, err)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+//go:build purego
+// +build purego
+
+package libucl
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate validates this object against the given JSON schema.
+func (o *Object) Validate(schema *Object) error {
+	v := &schemaValidator{root: schema.object}
+	return v.validate(schema.object, o.object, 0)
+}
+
+// maxSchemaDepth stops schemas with recursive references from recursing
+// forever.
+const maxSchemaDepth = 128
+
+// schemaValidator validates objects against a JSON schema (draft 4), the
+// same subset that libucl supports.
+type schemaValidator struct {
+	root *object
+}
+
+func schemaGet(schema *object, key string) *object {
+	if schema.typ != ObjectTypeObject {
+		return nil
+	}
+
+	return schema.index[key]
+}
+
+func (s *schemaValidator) validate(schema, v *object, depth int) error {
+	if depth > maxSchemaDepth {
+		return fmt.Errorf("schema is nested too deeply")
+	}
+	if schema.typ != ObjectTypeObject {
+		return fmt.Errorf("schema is %s instead of an object", schemaTypeName(schema))
+	}
+
+	if ref := schemaGet(schema, "$ref"); ref != nil {
+		target, err := s.resolve(ref.str)
+		if err != nil {
+			return err
+		}
+		return s.validate(target, v, depth+1)
+	}
+
+	if err := s.validateType(schema, v); err != nil {
+		return err
+	}
+	if enum := schemaGet(schema, "enum"); enum != nil {
+		found := false
+		for _, elem := range enum.elems {
+			if schemaEqual(elem, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("object is not one of enumerated patterns")
+		}
+	}
+
+	var err error
+	switch v.typ {
+	case ObjectTypeObject:
+		err = s.validateObject(schema, v, depth)
+	case ObjectTypeArray:
+		err = s.validateArray(schema, v, depth)
+	case ObjectTypeInt, ObjectTypeFloat, ObjectTypeTime:
+		err = validateNumber(schema, v)
+	case ObjectTypeString:
+		err = validateString(schema, v)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.validateCombined(schema, v, depth)
+}
+
+// resolve resolves a local reference such as "#/definitions/port".
+func (s *schemaValidator) resolve(ref string) (*object, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("reference %s is not local", ref)
+	}
+
+	result := s.root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+		result = schemaGet(result, part)
+		if result == nil {
+			return nil, fmt.Errorf("reference %s is invalid", ref)
+		}
+	}
+
+	return result, nil
+}
+
+func schemaTypeName(v *object) string {
+	switch v.typ {
+	case ObjectTypeObject:
+		return "object"
+	case ObjectTypeArray:
+		return "array"
+	case ObjectTypeInt:
+		return "integer"
+	case ObjectTypeFloat, ObjectTypeTime:
+		return "number"
+	case ObjectTypeString:
+		return "string"
+	case ObjectTypeBoolean:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+func (s *schemaValidator) validateType(schema, v *object) error {
+	typ := schemaGet(schema, "type")
+	if typ == nil {
+		return nil
+	}
+
+	names := []*object{typ}
+	if typ.typ == ObjectTypeArray {
+		names = typ.elems
+	}
+
+	actual := schemaTypeName(v)
+	expected := make([]string, 0, len(names))
+	for _, name := range names {
+		if name.str == actual || (name.str == "number" && actual == "integer") {
+			return nil
+		}
+		expected = append(expected, name.str)
+	}
+
+	return fmt.Errorf("Invalid type of %s, expected %s",
+		actual, strings.Join(expected, " or "))
+}
+
+func (s *schemaValidator) validateObject(schema, v *object, depth int) error {
+	if required := schemaGet(schema, "required"); required != nil {
+		for _, elem := range required.elems {
+			if _, ok := v.index[elem.str]; !ok {
+				return fmt.Errorf("object has no property %s", elem.str)
+			}
+		}
+	}
+	if max := schemaGet(schema, "maxProperties"); max != nil &&
+		int64(len(v.keys)) > schemaInt(max) {
+		return fmt.Errorf("object has more than %d properties", schemaInt(max))
+	}
+	if min := schemaGet(schema, "minProperties"); min != nil &&
+		int64(len(v.keys)) < schemaInt(min) {
+		return fmt.Errorf("object has less than %d properties", schemaInt(min))
+	}
+
+	props := schemaGet(schema, "properties")
+	patterns := schemaGet(schema, "patternProperties")
+	additional := schemaGet(schema, "additionalProperties")
+	for _, k := range v.keys {
+		matched := false
+		if props != nil {
+			if prop := schemaGet(props, k.key); prop != nil {
+				matched = true
+				if err := s.validateEach(prop, k, depth); err != nil {
+					return err
+				}
+			}
+		}
+		if patterns != nil {
+			for _, pattern := range patterns.keys {
+				re, err := regexp.Compile(pattern.key)
+				if err != nil {
+					return fmt.Errorf("invalid pattern %s: %s", pattern.key, err)
+				}
+				if re.MatchString(k.key) {
+					matched = true
+					if err := s.validateEach(pattern, k, depth); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if matched || additional == nil {
+			continue
+		}
+		if additional.typ == ObjectTypeBoolean {
+			if !additional.boolean {
+				return fmt.Errorf("object has non-allowed property %s", k.key)
+			}
+			continue
+		}
+		if err := s.validateEach(additional, k, depth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateEach validates every value of an implicit array.
+func (s *schemaValidator) validateEach(schema, v *object, depth int) error {
+	for ; v != nil; v = v.next {
+		if err := s.validate(schema, v, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *schemaValidator) validateArray(schema, v *object, depth int) error {
+	if max := schemaGet(schema, "maxItems"); max != nil &&
+		int64(len(v.elems)) > schemaInt(max) {
+		return fmt.Errorf("array has more than %d elements", schemaInt(max))
+	}
+	if min := schemaGet(schema, "minItems"); min != nil &&
+		int64(len(v.elems)) < schemaInt(min) {
+		return fmt.Errorf("array has less than %d elements", schemaInt(min))
+	}
+	if unique := schemaGet(schema, "uniqueItems"); unique != nil && unique.boolean {
+		for i, a := range v.elems {
+			for _, b := range v.elems[i+1:] {
+				if schemaEqual(a, b) {
+					return fmt.Errorf("duplicate values detected while uniqueItems is true")
+				}
+			}
+		}
+	}
+
+	items := schemaGet(schema, "items")
+	if items == nil {
+		return nil
+	}
+	if items.typ == ObjectTypeObject {
+		for _, elem := range v.elems {
+			if err := s.validate(items, elem, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// A list of schemas validates the elements in order, and the rest
+	// are validated by additionalItems.
+	for i, elem := range v.elems {
+		if i < len(items.elems) {
+			if err := s.validate(items.elems[i], elem, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		additional := schemaGet(schema, "additionalItems")
+		switch {
+		case additional == nil:
+		case additional.typ == ObjectTypeBoolean:
+			if !additional.boolean {
+				return fmt.Errorf("array has undefined item")
+			}
+		default:
+			if err := s.validate(additional, elem, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateNumber(schema, v *object) error {
+	f := (&Object{object: v}).ToFloat()
+
+	if m := schemaGet(schema, "multipleOf"); m != nil {
+		div := (&Object{object: m}).ToFloat()
+		if div != 0 {
+			q := f / div
+			if math.Abs(q-math.Round(q)) > 1e-9 {
+				return fmt.Errorf("number %s is not multiple of %s",
+					formatDouble(f), formatDouble(div))
+			}
+		}
+	}
+	if max := schemaGet(schema, "maximum"); max != nil {
+		limit := (&Object{object: max}).ToFloat()
+		exclusive := schemaGet(schema, "exclusiveMaximum")
+		if f > limit || (exclusive != nil && exclusive.boolean && f == limit) {
+			return fmt.Errorf("number is too big: %s, limit: %s",
+				formatDouble(f), formatDouble(limit))
+		}
+	}
+	if min := schemaGet(schema, "minimum"); min != nil {
+		limit := (&Object{object: min}).ToFloat()
+		exclusive := schemaGet(schema, "exclusiveMinimum")
+		if f < limit || (exclusive != nil && exclusive.boolean && f == limit) {
+			return fmt.Errorf("number is too small: %s, limit: %s",
+				formatDouble(f), formatDouble(limit))
+		}
+	}
+
+	return nil
+}
+
+func validateString(schema, v *object) error {
+	n := int64(utf8.RuneCountInString(v.str))
+	if max := schemaGet(schema, "maxLength"); max != nil && n > schemaInt(max) {
+		return fmt.Errorf("string is too big: %d, limit: %d", n, schemaInt(max))
+	}
+	if min := schemaGet(schema, "minLength"); min != nil && n < schemaInt(min) {
+		return fmt.Errorf("string is too short: %d, limit: %d", n, schemaInt(min))
+	}
+	if pattern := schemaGet(schema, "pattern"); pattern != nil {
+		re, err := regexp.Compile(pattern.str)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %s: %s", pattern.str, err)
+		}
+		if !re.MatchString(v.str) {
+			return fmt.Errorf("string doesn't match regexp %s", pattern.str)
+		}
+	}
+
+	return nil
+}
+
+func (s *schemaValidator) validateCombined(schema, v *object, depth int) error {
+	if all := schemaGet(schema, "allOf"); all != nil {
+		for _, sub := range all.elems {
+			if err := s.validate(sub, v, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if any := schemaGet(schema, "anyOf"); any != nil {
+		var err error
+		for _, sub := range any.elems {
+			if err = s.validate(sub, v, depth+1); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if one := schemaGet(schema, "oneOf"); one != nil {
+		matched := 0
+		for _, sub := range one.elems {
+			if s.validate(sub, v, depth+1) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("object matches %d schemas in oneOf", matched)
+		}
+	}
+	if not := schemaGet(schema, "not"); not != nil {
+		if s.validate(not, v, depth+1) == nil {
+			return fmt.Errorf("object matches not schema")
+		}
+	}
+
+	return nil
+}
+
+func schemaInt(v *object) int64 {
+	return (&Object{object: v}).ToInt()
+}
+
+// schemaEqual returns true if the values a and b are equal, comparing
+// numbers by value.
+func schemaEqual(a, b *object) bool {
+	an, bn := isSchemaNumber(a), isSchemaNumber(b)
+	if an || bn {
+		return an && bn &&
+			(&Object{object: a}).ToFloat() == (&Object{object: b}).ToFloat()
+	}
+	if a.typ != b.typ {
+		return false
+	}
+
+	switch a.typ {
+	case ObjectTypeString:
+		return a.str == b.str
+	case ObjectTypeBoolean:
+		return a.boolean == b.boolean
+	case ObjectTypeArray:
+		if len(a.elems) != len(b.elems) {
+			return false
+		}
+		for i := range a.elems {
+			if !schemaEqual(a.elems[i], b.elems[i]) {
+				return false
+			}
+		}
+		return true
+	case ObjectTypeObject:
+		if len(a.keys) != len(b.keys) {
+			return false
+		}
+		for _, k := range a.keys {
+			other, ok := b.index[k.key]
+			if !ok || !schemaEqual(k, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+func isSchemaNumber(v *object) bool {
+	return v.typ == ObjectTypeInt || v.typ == ObjectTypeFloat ||
+		v.typ == ObjectTypeTime
+}
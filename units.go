@@ -0,0 +1,210 @@
+package libucl
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteSize is a size in bytes. Like any integer, it can be decoded from
+// a number such as 512mb or from a string such as "512MB", using the
+// libucl multipliers: k, m and g are powers of 1000, and kb, mb and gb
+// are powers of 1024.
+type ByteSize int64
+
+// String returns the size using the largest binary multiplier that
+// represents it exactly, such as "512mb". The result can be parsed by
+// libucl back into the same size.
+func (b ByteSize) String() string {
+	switch {
+	case b == 0:
+		return "0"
+	case b%(1<<30) == 0:
+		return fmt.Sprintf("%dgb", b/(1<<30))
+	case b%(1<<20) == 0:
+		return fmt.Sprintf("%dmb", b/(1<<20))
+	case b%(1<<10) == 0:
+		return fmt.Sprintf("%dkb", b/(1<<10))
+	default:
+		return strconv.FormatInt(int64(b), 10)
+	}
+}
+
+// numberSuffix is a multiplier that libucl understands as a suffix of
+// a number.
+type numberSuffix struct {
+	suffix string
+	mult   float64
+	time   bool
+}
+
+// numberSuffixes are the suffixes libucl understands, longest first so
+// that "min" is matched before "m". The time suffixes convert to seconds.
+var numberSuffixes = []numberSuffix{
+	{"min", 60, true},
+	{"kb", 1 << 10, false},
+	{"mb", 1 << 20, false},
+	{"gb", 1 << 30, false},
+	{"ms", 0.001, true},
+	{"k", 1e3, false},
+	{"m", 1e6, false},
+	{"g", 1e9, false},
+	{"s", 1, true},
+	{"h", 60 * 60, true},
+	{"d", 24 * 60 * 60, true},
+	{"w", 7 * 24 * 60 * 60, true},
+	{"y", 365 * 24 * 60 * 60, true},
+}
+
+// splitNumber splits a number from its suffix and returns the multiplier
+// for the suffix, and whether it is a time suffix. Hex numbers can't have
+// a suffix.
+func splitNumber(s string) (string, float64, bool) {
+	s = strings.TrimSpace(s)
+
+	unsigned := strings.TrimLeft(s, "+-")
+	if strings.HasPrefix(unsigned, "0x") || strings.HasPrefix(unsigned, "0X") {
+		return s, 1, false
+	}
+
+	lower := strings.ToLower(s)
+	for _, ns := range numberSuffixes {
+		if strings.HasSuffix(lower, ns.suffix) {
+			return s[:len(s)-len(ns.suffix)], ns.mult, ns.time
+		}
+	}
+
+	return s, 1, false
+}
+
+// parseInt parses an integer with an optional libucl suffix. Fractional
+// results are truncated, the same as libucl does when a number such as
+// 1.5k is read as an integer, except for times: 100ms isn't a whole
+// number of seconds, so it is an error.
+func parseInt(s string) (int64, error) {
+	num, mult, isTime := splitNumber(s)
+	if mult == math.Trunc(mult) {
+		if i, err := strconv.ParseInt(num, 0, 64); err == nil {
+			result := i * int64(mult)
+			if i != 0 && result/i != int64(mult) {
+				return 0, fmt.Errorf("%q is out of range", s)
+			}
+
+			return result, nil
+		}
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	if f *= mult; isTime && f != math.Trunc(f) {
+		return 0, fmt.Errorf("%q is not a whole number of seconds", s)
+	}
+	f = math.Trunc(f)
+	if f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, fmt.Errorf("%q is out of range", s)
+	}
+
+	return int64(f), nil
+}
+
+// parseUint is like parseInt, but for unsigned integers.
+func parseUint(s string) (uint64, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "-") {
+		return 0, fmt.Errorf("%q is negative", s)
+	}
+
+	num, mult, isTime := splitNumber(s)
+	if mult == math.Trunc(mult) {
+		if i, err := strconv.ParseUint(num, 0, 64); err == nil {
+			result := i * uint64(mult)
+			if i != 0 && result/i != uint64(mult) {
+				return 0, fmt.Errorf("%q is out of range", s)
+			}
+
+			return result, nil
+		}
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	if f *= mult; isTime && f != math.Trunc(f) {
+		return 0, fmt.Errorf("%q is not a whole number of seconds", s)
+	}
+	f = math.Trunc(f)
+	if f >= math.MaxUint64 {
+		return 0, fmt.Errorf("%q is out of range", s)
+	}
+
+	return uint64(f), nil
+}
+
+// parseFloat parses a float with an optional libucl suffix. A float
+// can also be a percentage such as "50%", which is parsed as 0.5.
+func parseFloat(s string) (float64, error) {
+	if trimmed := strings.TrimSpace(s); strings.HasSuffix(trimmed, "%") {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(trimmed, "%"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid percentage %q", s)
+		}
+
+		return f / 100, nil
+	}
+
+	num, mult, _ := splitNumber(s)
+	if i, err := strconv.ParseInt(num, 0, 64); err == nil {
+		return float64(i) * mult, nil
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	return f * mult, nil
+}
+
+// parseDuration parses a time with a libucl suffix such as "30s" or
+// "100ms", which libucl reads as seconds. It returns false if s doesn't
+// have a time suffix.
+func parseDuration(s string) (time.Duration, bool, error) {
+	num, mult, isTime := splitNumber(s)
+	if !isTime {
+		return 0, false, nil
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid number %q", s)
+	}
+
+	d, err := secondsDuration(f * mult)
+	return d, true, err
+}
+
+// secondsDuration converts a number of seconds to a time.Duration.
+func secondsDuration(secs float64) (time.Duration, error) {
+	ns := math.Round(secs * float64(time.Second))
+	if ns < math.MinInt64 || ns >= math.MaxInt64 {
+		return 0, fmt.Errorf("%s seconds is out of range", formatDouble(secs))
+	}
+
+	return time.Duration(ns), nil
+}
+
+// formatDouble formats a float the same way as the libucl emitters.
+func formatDouble(f float64) string {
+	switch {
+	case f == math.Trunc(f):
+		return strconv.FormatFloat(f, 'f', 1, 64)
+	case math.Abs(f-math.Trunc(f)) < 0.0000001:
+		return strconv.FormatFloat(f, 'g', 15, 64)
+	default:
+		return strconv.FormatFloat(f, 'f', 6, 64)
+	}
+}
@@ -0,0 +1,136 @@
+package libucl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestByteSizeString(t *testing.T) {
+	cases := map[ByteSize]string{
+		0:             "0",
+		1000:          "1000",
+		1024:          "1kb",
+		512 << 20:     "512mb",
+		3 << 30:       "3gb",
+		(1 << 20) + 1: "1048577",
+	}
+
+	for size, expected := range cases {
+		if actual := size.String(); actual != expected {
+			t.Fatalf("bad %d: %s", int64(size), actual)
+		}
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected int64
+		Err      bool
+	}{
+		{"42", 42, false},
+		{"-42", -42, false},
+		{"0x10", 16, false},
+		{"10k", 10000, false},
+		{"10K", 10000, false},
+		{"10kb", 10240, false},
+		{"512MB", 512 << 20, false},
+		{"2g", 2000000000, false},
+		{"1.5k", 1500, false},
+		{"1min", 60, false},
+		{"2h", 7200, false},
+		{"1d", 86400, false},
+		{"1w", 604800, false},
+		{"1500ms", 0, true},
+		{"2000ms", 2, false},
+		{"10gb", 10 << 30, false},
+		{"9999999999gb", 0, true},
+		{"", 0, true},
+		{"foo", 0, true},
+		{"10xb", 0, true},
+	}
+
+	for _, tc := range cases {
+		actual, err := parseInt(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("bad %q: %s", tc.Input, err)
+		}
+		if err == nil && actual != tc.Expected {
+			t.Fatalf("bad %q: %d", tc.Input, actual)
+		}
+	}
+}
+
+func TestParseUint(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected uint64
+		Err      bool
+	}{
+		{"42", 42, false},
+		{"1kb", 1024, false},
+		{"-1", 0, true},
+	}
+
+	for _, tc := range cases {
+		actual, err := parseUint(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("bad %q: %s", tc.Input, err)
+		}
+		if err == nil && actual != tc.Expected {
+			t.Fatalf("bad %q: %d", tc.Input, actual)
+		}
+	}
+}
+
+func TestParseFloat(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected float64
+		Err      bool
+	}{
+		{"1.5", 1.5, false},
+		{"50%", 0.5, false},
+		{"2.5k", 2500, false},
+		{"10ms", 0.01, false},
+		{"1kb", 1024, false},
+		{"%", 0, true},
+		{"foo", 0, true},
+	}
+
+	for _, tc := range cases {
+		actual, err := parseFloat(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("bad %q: %s", tc.Input, err)
+		}
+		if err == nil && actual != tc.Expected {
+			t.Fatalf("bad %q: %v", tc.Input, actual)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected time.Duration
+		OK       bool
+	}{
+		{"30s", 30 * time.Second, true},
+		{"100ms", 100 * time.Millisecond, true},
+		{"1.5min", 90 * time.Second, true},
+		{"2h", 2 * time.Hour, true},
+		{"30", 0, false},
+		{"10m", 0, false},
+		{"512mb", 0, false},
+	}
+
+	for _, tc := range cases {
+		actual, ok, err := parseDuration(tc.Input)
+		if err != nil {
+			t.Fatalf("err %q: %s", tc.Input, err)
+		}
+		if ok != tc.OK || actual != tc.Expected {
+			t.Fatalf("bad %q: %s %#v", tc.Input, actual, ok)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+//go:build !purego
+// +build !purego
+
+package libucl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestUpstream parses the inputs of libucl's tests/basic suite, if the
+// Makefile has vendored libucl. Like libucl's test_basic, each
+// input is emitted with EmitConfig and must match the expected result
+// byte for byte. Each input must also round trip through every emitter,
+// so that a change in behavior is caught when libucl is updated.
+func TestUpstream(t *testing.T) {
+	paths, err := filepath.Glob(
+		filepath.Join("vendor", "libucl", "tests", "basic", "*.in"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(paths) == 0 {
+		t.Skip("libucl isn't vendored")
+	}
+
+	for _, path := range paths {
+		obj := testParseUpstream(t, path)
+		if obj == nil {
+			continue
+		}
+
+		// test_basic writes the emitted object followed by a newline.
+		res := strings.TrimSuffix(path, ".in") + ".res"
+		if expected, err := ioutil.ReadFile(res); err == nil {
+			actual, err := obj.Emit(EmitConfig)
+			if err != nil {
+				t.Fatalf("%s: err: %s", path, err)
+			}
+			if actual+"\n" != string(expected) {
+				t.Fatalf("%s: doesn't match %s:\n\n%s", path, res, actual)
+			}
+		}
+
+		for _, e := range goldenEmitters {
+			if !e.Parse {
+				continue
+			}
+
+			data, err := obj.Emit(e.Emitter)
+			if err != nil {
+				t.Fatalf("%s: err: %s", path, err)
+			}
+			testRoundTrip(t, path, obj, e.Emitter, data)
+		}
+
+		obj.Close()
+	}
+}
+
+// testParseUpstream parses a file the same way as libucl's test_basic,
+// which sets the file variables from the path of the file.
+func testParseUpstream(t *testing.T, path string) *Object {
+	p := NewParser(ParserKeyLowercase)
+	defer p.Close()
+
+	p.RegisterVariable("ABI", "unknown")
+	if err := p.AddFile(path); err != nil {
+		t.Fatalf("%s: err: %s", path, err)
+	}
+
+	return p.Object()
+}
@@ -0,0 +1,82 @@
+package libucl
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// expandVariables expands the variables in s the same way libucl does,
+// as $NAME or ${NAME}. Unknown variables are left as they are, and false
+// is returned if there were any.
+func expandVariables(s string, variables map[string]string) (string, bool) {
+	if !strings.Contains(s, "$") {
+		return s, true
+	}
+
+	// Longer names are tried first, so that $FOOBAR isn't expanded as
+	// $FOO followed by "BAR".
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+	known := true
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			buf.WriteByte(s[i])
+			continue
+		}
+
+		rest := s[i+1:]
+		if strings.HasPrefix(rest, "{") {
+			if end := strings.IndexByte(rest, '}'); end > 0 {
+				if v, ok := variables[rest[1:end]]; ok {
+					buf.WriteString(v)
+					i += end + 1
+					continue
+				}
+			}
+		} else {
+			found := false
+			for _, name := range names {
+				if strings.HasPrefix(rest, name) {
+					buf.WriteString(variables[name])
+					i += len(name)
+					found = true
+					break
+				}
+			}
+			if found {
+				continue
+			}
+		}
+
+		buf.WriteByte('$')
+		known = false
+	}
+
+	return buf.String(), known
+}
+
+// fileVariables returns the values libucl gives the FILENAME and CURDIR
+// variables for the file at path, or for the working directory if path
+// is empty.
+func fileVariables(path string) (filename, curdir string) {
+	if path == "" {
+		dir, _ := os.Getwd()
+		return "undef", dir
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+
+	return path, filepath.Dir(path)
+}